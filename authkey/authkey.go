@@ -2,6 +2,7 @@ package authkey
 
 import (
 	"crypto/sha256"
+	"errors"
 
 	"golang.org/x/crypto/pbkdf2"
 )
@@ -19,7 +20,31 @@ const (
 
 // NewFromPassword derives an AuthKey using pkdf2 as specified in the HSM documentation
 func NewFromPassword(password string) AuthKey {
-	return pbkdf2.Key([]byte(password), []byte(yubicoSeed), authKeyIterations, authKeyLength, sha256.New)
+	return NewFromPasswordWithIterations(password, authKeyIterations)
+}
+
+// NewFromPasswordWithIterations derives an AuthKey using pkdf2 with a custom number of iterations.
+// The HSM only stores the derived keys, so the same iteration count has to be used when the key is created and when
+// authenticating; use securechannel.NewSecureChannelWithKeys with the enc and mac key of the result to authenticate.
+func NewFromPasswordWithIterations(password string, iterations int) AuthKey {
+	return pbkdf2.Key([]byte(password), []byte(yubicoSeed), iterations, authKeyLength, sha256.New)
+}
+
+// NewFromKeys creates an AuthKey from a raw encryption and MAC key as used by authentication keys that are not
+// derived from a password
+func NewFromKeys(encKey, macKey []byte) (AuthKey, error) {
+	if len(encKey) != authKeyLength/2 {
+		return nil, errors.New("invalid encryption key length")
+	}
+	if len(macKey) != authKeyLength/2 {
+		return nil, errors.New("invalid mac key length")
+	}
+
+	key := make(AuthKey, 0, authKeyLength)
+	key = append(key, encKey...)
+	key = append(key, macKey...)
+
+	return key, nil
 }
 
 // GetEncKey returns the EncryptionKey part of the AuthKey
@@ -0,0 +1,111 @@
+package yubihsm
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/certusone/yubihsm-go/commands"
+)
+
+// ErrBatchAborted is recorded for the commands of a batch that were not sent because an earlier command failed with
+// an error other than an HSM error
+var ErrBatchAborted = errors.New("command was not sent because the batch was aborted")
+
+// BatchError is returned by SendEncryptedCommands if at least one command of the batch failed
+type BatchError struct {
+	// Errors contains the error of every command of the batch by index; it is nil for commands that succeeded
+	Errors []error
+}
+
+func (e *BatchError) Error() string {
+	failed := 0
+	for _, err := range e.Errors {
+		if err != nil {
+			failed++
+		}
+	}
+
+	index, err := e.first()
+	return fmt.Sprintf("%d of %d commands failed; command %d: %v", failed, len(e.Errors), index, err)
+}
+
+// Unwrap returns the error of the first failed command
+func (e *BatchError) Unwrap() error {
+	_, err := e.first()
+	return err
+}
+
+func (e *BatchError) first() (int, error) {
+	for i, err := range e.Errors {
+		if err != nil && err != ErrBatchAborted {
+			return i, err
+		}
+	}
+
+	return -1, nil
+}
+
+// SendEncryptedCommands sends a batch of encrypted commands and returns their responses in the same order. The
+// commands are distributed across the sessions of the pool, so commands of the batch may run in parallel and their
+// order of execution is not guaranteed.
+// Errors returned by the HSM (*commands.Error) are collected and the remaining commands are still sent. Any other
+// error, e.g. a connector or session failure, aborts the batch and the commands not yet sent fail with
+// ErrBatchAborted. If any command failed, a *BatchError is returned along with the responses of the successful commands.
+func (s *SessionManager) SendEncryptedCommands(cmds []*commands.CommandMessage) ([]commands.Response, error) {
+	responses := make([]commands.Response, len(cmds))
+	errs := make([]error, len(cmds))
+
+	var lock sync.Mutex
+	next := 0
+	aborted := false
+
+	workers := int(s.poolSize)
+	if workers > len(cmds) {
+		workers = len(cmds)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				lock.Lock()
+				if next >= len(cmds) {
+					lock.Unlock()
+					return
+				}
+				i := next
+				next++
+				if aborted {
+					errs[i] = ErrBatchAborted
+					lock.Unlock()
+					continue
+				}
+				lock.Unlock()
+
+				resp, err := s.SendEncryptedCommand(cmds[i])
+
+				lock.Lock()
+				responses[i] = resp
+				errs[i] = err
+				var hsmErr *commands.Error
+				if err != nil && !errors.As(err, &hsmErr) {
+					aborted = true
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return responses, &BatchError{Errors: errs}
+		}
+	}
+
+	return responses, nil
+}
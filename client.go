@@ -0,0 +1,239 @@
+package yubihsm
+
+import (
+	"crypto"
+	"fmt"
+
+	"github.com/certusone/yubihsm-go/commands"
+)
+
+// Client wraps a SessionManager and provides typed methods for common operations, so that callers don't need to
+// create commands and assert response types themselves. Use the SessionManager for commands the Client does not cover.
+type Client struct {
+	manager *SessionManager
+}
+
+// NewClient creates a new instance of Client that sends its commands using manager
+func NewClient(manager *SessionManager) *Client {
+	return &Client{
+		manager: manager,
+	}
+}
+
+// SessionManager returns the SessionManager used by the client
+func (c *Client) SessionManager() *SessionManager {
+	return c.manager
+}
+
+// GenerateEd25519Key generates an Ed25519 key on the HSM and returns its object ID.
+// If id is 0 the HSM picks a free ID.
+func (c *Client) GenerateEd25519Key(id uint16, label string, domains uint16, capabilities uint64) (uint16, error) {
+	return c.generateAsymmetricKey(id, label, domains, capabilities, commands.AlgorithmED25519)
+}
+
+// GenerateECKey generates an EC key using the curve of algorithm on the HSM and returns its object ID.
+// If id is 0 the HSM picks a free ID.
+func (c *Client) GenerateECKey(id uint16, label string, domains uint16, capabilities uint64, algorithm commands.Algorithm) (uint16, error) {
+	return c.generateAsymmetricKey(id, label, domains, capabilities, algorithm)
+}
+
+// SignEddsa signs message using the Ed25519 key id and returns the 64 byte signature
+func (c *Client) SignEddsa(id uint16, message []byte) ([]byte, error) {
+	command, err := commands.CreateSignDataEddsaCommand(id, message)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.manager.SendEncryptedCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedResp, matched := resp.(*commands.SignDataEddsaResponse)
+	if !matched {
+		return nil, ErrInvalidResponseType
+	}
+
+	return parsedResp.Signature, nil
+}
+
+// SignEcdsa signs digest using the EC key id and returns the DER encoded signature
+func (c *Client) SignEcdsa(id uint16, digest []byte) ([]byte, error) {
+	command, err := commands.CreateSignDataEcdsaCommand(id, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.manager.SendEncryptedCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedResp, matched := resp.(*commands.SignDataEcdsaResponse)
+	if !matched {
+		return nil, ErrInvalidResponseType
+	}
+
+	return parsedResp.Signature, nil
+}
+
+// SignEcdsaMessage hashes message using hash and signs the digest using the EC key id. It returns the DER encoded
+// signature. The digest length has to match the curve of the key, e.g. SHA-256 for P256 or SHA-512 for P521.
+func (c *Client) SignEcdsaMessage(id uint16, message []byte, hash crypto.Hash) ([]byte, error) {
+	if !hash.Available() {
+		return nil, fmt.Errorf("hash function %d is not available", hash)
+	}
+
+	publicKey, err := c.getPubKey(id)
+	if err != nil {
+		return nil, err
+	}
+
+	expected, err := commands.ECDSADigestLength(publicKey.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if hash.Size() != expected {
+		return nil, fmt.Errorf("digest length %d does not match the %d bytes expected for %s", hash.Size(), expected, publicKey.Algorithm)
+	}
+
+	h := hash.New()
+	h.Write(message)
+
+	return c.SignEcdsa(id, h.Sum(nil))
+}
+
+// SignPkcs1 signs digest using RSASSA-PKCS1-v1_5 with the RSA key id. digest must be a SHA-1, SHA-256, SHA-384 or
+// SHA-512 digest of the message.
+func (c *Client) SignPkcs1(id uint16, digest []byte) ([]byte, error) {
+	command, err := commands.CreateSignDataPkcs1Command(id, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.manager.SendEncryptedCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedResp, matched := resp.(*commands.SignDataPkcs1Response)
+	if !matched {
+		return nil, ErrInvalidResponseType
+	}
+
+	return parsedResp.Signature, nil
+}
+
+// SignPkcs1Message hashes message using hash and signs the digest using RSASSA-PKCS1-v1_5 with the RSA key id
+func (c *Client) SignPkcs1Message(id uint16, message []byte, hash crypto.Hash) ([]byte, error) {
+	if !hash.Available() {
+		return nil, fmt.Errorf("hash function %d is not available", hash)
+	}
+
+	h := hash.New()
+	h.Write(message)
+
+	return c.SignPkcs1(id, h.Sum(nil))
+}
+
+// GetPublicKey returns the public key of the asymmetric key id as *ecdsa.PublicKey, ed25519.PublicKey or
+// *rsa.PublicKey
+func (c *Client) GetPublicKey(id uint16) (crypto.PublicKey, error) {
+	parsedResp, err := c.getPubKey(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsedResp.PublicKey()
+}
+
+// Seal encrypts plaintext using the wrap key wrapKeyID and returns the nonce chosen by the HSM followed by the
+// ciphertext. The blob can be decrypted using Open.
+func (c *Client) Seal(wrapKeyID uint16, plaintext []byte) ([]byte, error) {
+	command, err := commands.CreateWrapDataCommand(wrapKeyID, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.manager.SendEncryptedCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedResp, matched := resp.(*commands.WrapDataResponse)
+	if !matched {
+		return nil, ErrInvalidResponseType
+	}
+
+	blob := make([]byte, 0, len(parsedResp.Nonce)+len(parsedResp.Data))
+	blob = append(blob, parsedResp.Nonce...)
+	return append(blob, parsedResp.Data...), nil
+}
+
+// Open decrypts a blob created by Seal using the wrap key wrapKeyID
+func (c *Client) Open(wrapKeyID uint16, blob []byte) ([]byte, error) {
+	if len(blob) < commands.WrapNonceLength {
+		return nil, fmt.Errorf("sealed data is %d bytes long and shorter than the %d byte nonce", len(blob), commands.WrapNonceLength)
+	}
+
+	command, err := commands.CreateUnwrapDataCommand(wrapKeyID, blob[:commands.WrapNonceLength], blob[commands.WrapNonceLength:])
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.manager.SendEncryptedCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedResp, matched := resp.(*commands.UnwrapDataResponse)
+	if !matched {
+		return nil, ErrInvalidResponseType
+	}
+
+	return parsedResp.Data, nil
+}
+
+// DeleteObject deletes the object id of type objType from the HSM. It returns ErrObjectNotFound if the object does
+// not exist.
+func (c *Client) DeleteObject(id uint16, objType uint8) error {
+	return c.manager.DeleteObject(id, objType)
+}
+
+func (c *Client) getPubKey(id uint16) (*commands.GetPubKeyResponse, error) {
+	command, err := commands.CreateGetPubKeyCommand(id)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.manager.SendEncryptedCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedResp, matched := resp.(*commands.GetPubKeyResponse)
+	if !matched {
+		return nil, ErrInvalidResponseType
+	}
+
+	return parsedResp, nil
+}
+
+func (c *Client) generateAsymmetricKey(id uint16, label string, domains uint16, capabilities uint64, algorithm commands.Algorithm) (uint16, error) {
+	command, err := commands.CreateGenerateAsymmetricKeyCommand(id, []byte(label), domains, capabilities, algorithm)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := c.manager.SendEncryptedCommand(command)
+	if err != nil {
+		return 0, err
+	}
+
+	parsedResp, matched := resp.(*commands.CreateAsymmetricKeyResponse)
+	if !matched {
+		return 0, ErrInvalidResponseType
+	}
+
+	return parsedResp.KeyID, nil
+}
@@ -0,0 +1,56 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+)
+
+// backupLineLength is the line length of the base64 encoding used by yubihsm-shell, which wraps lines like OpenSSL
+const backupLineLength = 64
+
+// Backup encodes the wrapped object in the format written by yubihsm-shell's get-wrapped command: the nonce followed
+// by the wrapped data, base64 encoded with lines of 64 characters.
+func (r *ExportWrappedResponse) Backup() []byte {
+	raw := make([]byte, 0, len(r.Nonce)+len(r.Data))
+	raw = append(raw, r.Nonce...)
+	raw = append(raw, r.Data...)
+
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(raw)))
+	base64.StdEncoding.Encode(encoded, raw)
+
+	out := new(bytes.Buffer)
+	for len(encoded) > 0 {
+		n := backupLineLength
+		if n > len(encoded) {
+			n = len(encoded)
+		}
+		out.Write(encoded[:n])
+		out.WriteByte('\n')
+		encoded = encoded[n:]
+	}
+
+	return out.Bytes()
+}
+
+// ParseBackup decodes a wrapped object written by Backup or yubihsm-shell's get-wrapped command. The nonce and data
+// of the result can be passed to CreateImportWrappedCommand.
+func ParseBackup(backup []byte) (*ExportWrappedResponse, error) {
+	encoded := bytes.Join(bytes.Fields(backup), nil)
+
+	raw := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
+	n, err := base64.StdEncoding.Decode(raw, encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup encoding: %w", err)
+	}
+	raw = raw[:n]
+
+	if len(raw) <= WrapNonceLength {
+		return nil, fmt.Errorf("backup is %d bytes long and contains no wrapped data", len(raw))
+	}
+
+	return &ExportWrappedResponse{
+		Nonce: raw[:WrapNonceLength],
+		Data:  raw[WrapNonceLength:],
+	}, nil
+}
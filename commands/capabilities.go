@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Capabilities is a set of Capability flags
+type Capabilities uint64
+
+// capabilityNames maps capabilities to the names used by yubihsm-shell in the order of their bits
+var capabilityNames = []struct {
+	capability uint64
+	name       string
+}{
+	{CapabilityGetOpaque, "get-opaque"},
+	{CapabilityPutOpaque, "put-opaque"},
+	{CapabilityPutAuthenticationKey, "put-authentication-key"},
+	{CapabilityPutAsymmetric, "put-asymmetric-key"},
+	{CapabilityAsymmetricGen, "generate-asymmetric-key"},
+	{CapabilityAsymmetricSignPkcs, "sign-pkcs"},
+	{CapabilityAsymmetricSignPss, "sign-pss"},
+	{CapabilityAsymmetricSignEcdsa, "sign-ecdsa"},
+	{CapabilityAsymmetricSignEddsa, "sign-eddsa"},
+	{CapabilityAsymmetricDecryptPkcs, "decrypt-pkcs"},
+	{CapabilityAsymmetricDecryptOaep, "decrypt-oaep"},
+	{CapabilityAsymmetricDeriveEcdh, "derive-ecdh"},
+	{CapabilityExportWrapped, "export-wrapped"},
+	{CapabilityImportWrapped, "import-wrapped"},
+	{CapabilityPutWrapKey, "put-wrap-key"},
+	{CapabilityGenerateWrapKey, "generate-wrap-key"},
+	{CapabilityExportableUnderWrap, "exportable-under-wrap"},
+	{CapabilityPutOption, "set-option"},
+	{CapabilityGetOption, "get-option"},
+	{CapabilityGetRandomness, "get-pseudo-random"},
+	{CapabilityPutHmacKey, "put-mac-key"},
+	{CapabilityHmacKeyGenerate, "generate-hmac-key"},
+	{CapabilityHmacData, "sign-hmac"},
+	{CapabilityHmacVerify, "verify-hmac"},
+	{CapabilityAudit, "get-log-entries"},
+	{CapabilitySshCertify, "sign-ssh-certificate"},
+	{CapabilityGetTemplate, "get-template"},
+	{CapabilityPutTemplate, "put-template"},
+	{CapabilityReset, "reset-device"},
+	{CapabilityOtpDecrypt, "decrypt-otp"},
+	{CapabilityOtpAeadCreate, "create-otp-aead"},
+	{CapabilityOtpAeadRandom, "randomize-otp-aead"},
+	{CapabilityOtpAeadRewrapFrom, "rewrap-from-otp-aead-key"},
+	{CapabilityOtpAeadRewrapTo, "rewrap-to-otp-aead-key"},
+	{CapabilityAttest, "sign-attestation-certificate"},
+	{CapabilityPutOtpAeadKey, "put-otp-aead-key"},
+	{CapabilityGenerateOtpAeadKey, "generate-otp-aead-key"},
+	{CapabilityWrapData, "wrap-data"},
+	{CapabilityUnwrapData, "unwrap-data"},
+	{CapabilityDeleteOpaque, "delete-opaque"},
+	{CapabilityDeleteAuthKey, "delete-authentication-key"},
+	{CapabilityDeleteAsymmetric, "delete-asymmetric-key"},
+	{CapabilityDeleteWrapKey, "delete-wrap-key"},
+	{CapabilityDeleteHmacKey, "delete-hmac-key"},
+	{CapabilityDeleteTemplate, "delete-template"},
+	{CapabilityDeleteOtpAeadKey, "delete-otp-aead-key"},
+	{CapabilityChangeAuthenticationKey, "change-authentication-key"},
+}
+
+// ParseCapabilities converts capability names as used by yubihsm-shell (e.g. "sign-ecdsa") to a capability bitmask
+func ParseCapabilities(names ...string) (uint64, error) {
+	var capabilities uint64
+
+nameLoop:
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		for _, c := range capabilityNames {
+			if c.name == name {
+				capabilities |= c.capability
+				continue nameLoop
+			}
+		}
+
+		return 0, fmt.Errorf("unknown capability %q", name)
+	}
+
+	return capabilities, nil
+}
+
+// Has returns whether all capabilities of c are part of the set
+func (c Capabilities) Has(capabilities uint64) bool {
+	return uint64(c)&capabilities == capabilities
+}
+
+// Strings returns the yubihsm-shell names of the capabilities in the set. Unknown bits are formatted as hex values.
+func (c Capabilities) Strings() []string {
+	var names []string
+	remaining := uint64(c)
+	for _, capability := range capabilityNames {
+		if remaining&capability.capability != 0 {
+			names = append(names, capability.name)
+			remaining &^= capability.capability
+		}
+	}
+
+	for bit := uint(0); bit < 64; bit++ {
+		if remaining&(1<<bit) != 0 {
+			names = append(names, fmt.Sprintf("0x%016x", uint64(1)<<bit))
+		}
+	}
+
+	return names
+}
+
+// String returns the comma separated names of the capabilities in the set
+func (c Capabilities) String() string {
+	return strings.Join(c.Strings(), ",")
+}
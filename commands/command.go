@@ -3,6 +3,8 @@ package commands
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"io"
 )
 
 type (
@@ -15,7 +17,14 @@ type (
 	}
 )
 
+// BodyLength returns the length of the command without its header. It is truncated for commands that exceed
+// MaxMessageSize, which can't be serialized.
 func (c *CommandMessage) BodyLength() uint16 {
+	return uint16(c.bodyLength())
+}
+
+// bodyLength returns the length of the session ID, data and MAC of the command
+func (c *CommandMessage) bodyLength() int {
 	length := len(c.Data)
 
 	if c.MAC != nil {
@@ -26,28 +35,51 @@ func (c *CommandMessage) BodyLength() uint16 {
 		length += 1
 	}
 
-	return uint16(length)
+	return length
 }
 
 func (c *CommandMessage) Serialize() ([]byte, error) {
-	buffer := new(bytes.Buffer)
+	buffer := bytes.NewBuffer(make([]byte, 0, 3+c.bodyLength()))
+
+	err := c.SerializeTo(buffer)
+	if err != nil {
+		return nil, err
+	}
 
-	// Write command type
-	binary.Write(buffer, binary.BigEndian, c.CommandType)
+	return buffer.Bytes(), nil
+}
 
-	// Write length
-	binary.Write(buffer, binary.BigEndian, uint16(c.BodyLength()))
+// SerializeTo writes the serialized command to w. Unlike Serialize it does not allocate a new buffer, so it can be
+// used with a reused buffer on hot paths.
+func (c *CommandMessage) SerializeTo(w io.Writer) error {
+	// The length is checked before it is converted to the uint16 of the header, which would wrap for large commands
+	bodyLength := c.bodyLength()
+	if length := 3 + bodyLength; length > MaxMessageSize {
+		return fmt.Errorf("command is %d bytes long and exceeds the maximum message size of %d bytes", length, MaxMessageSize)
+	}
 
-	// Write sessionID
+	// Write command type, length and sessionID
+	var header [4]byte
+	header[0] = byte(c.CommandType)
+	binary.BigEndian.PutUint16(header[1:3], uint16(bodyLength))
+	headerLength := 3
 	if c.SessionID != nil {
-		binary.Write(buffer, binary.BigEndian, *c.SessionID)
+		header[3] = *c.SessionID
+		headerLength++
+	}
+
+	_, err := w.Write(header[:headerLength])
+	if err != nil {
+		return err
 	}
 
 	// Write data
-	buffer.Write(c.Data)
+	_, err = w.Write(c.Data)
+	if err != nil {
+		return err
+	}
 
 	// Write MAC
-	buffer.Write(c.MAC)
-
-	return buffer.Bytes(), nil
+	_, err = w.Write(c.MAC)
+	return err
 }
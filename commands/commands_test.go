@@ -0,0 +1,429 @@
+package commands_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/certusone/yubihsm-go/commands"
+)
+
+func TestCapabilitiesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name         string
+		names        []string
+		capabilities uint64
+	}{
+		{
+			name:         "none",
+			names:        nil,
+			capabilities: commands.CapabilityNone,
+		},
+		{
+			name:         "single",
+			names:        []string{"sign-ecdsa"},
+			capabilities: commands.CapabilityAsymmetricSignEcdsa,
+		},
+		{
+			name:         "lowest and highest bit",
+			names:        []string{"get-opaque", "change-authentication-key"},
+			capabilities: commands.CapabilityGetOpaque | commands.CapabilityChangeAuthenticationKey,
+		},
+		{
+			name:         "several",
+			names:        []string{"sign-pkcs", "sign-pss", "export-wrapped", "get-log-entries"},
+			capabilities: commands.CapabilityAsymmetricSignPkcs | commands.CapabilityAsymmetricSignPss | commands.CapabilityExportWrapped | commands.CapabilityAudit,
+		},
+	}
+
+	for _, test := range tests {
+		capabilities, err := commands.ParseCapabilities(test.names...)
+		if err != nil {
+			t.Errorf("%s: parsing capabilities failed: %v", test.name, err)
+			continue
+		}
+		if capabilities != test.capabilities {
+			t.Errorf("%s: parsed 0x%016x, expected 0x%016x", test.name, capabilities, test.capabilities)
+		}
+
+		names := commands.Capabilities(test.capabilities).Strings()
+		if !reflect.DeepEqual(names, test.names) {
+			t.Errorf("%s: formatted %v, expected %v", test.name, names, test.names)
+		}
+		if s := commands.Capabilities(test.capabilities).String(); s != strings.Join(test.names, ",") {
+			t.Errorf("%s: formatted %q, expected %q", test.name, s, strings.Join(test.names, ","))
+		}
+	}
+}
+
+func TestCapabilitiesFormatting(t *testing.T) {
+	tests := []struct {
+		name         string
+		capabilities commands.Capabilities
+		expected     []string
+	}{
+		{
+			name:         "unknown bit",
+			capabilities: commands.Capabilities(1 << 63),
+			expected:     []string{"0x8000000000000000"},
+		},
+		{
+			name:         "known and unknown bits",
+			capabilities: commands.Capabilities(commands.CapabilityReset | 1<<48),
+			expected:     []string{"reset-device", "0x0001000000000000"},
+		},
+	}
+
+	for _, test := range tests {
+		names := test.capabilities.Strings()
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("%s: formatted %v, expected %v", test.name, names, test.expected)
+		}
+	}
+}
+
+func TestParseCapabilitiesInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+	}{
+		{name: "unknown", names: []string{"sign-everything"}},
+		{name: "unknown after known", names: []string{"sign-ecdsa", "sign-everything"}},
+		{name: "empty", names: []string{""}},
+	}
+
+	for _, test := range tests {
+		_, err := commands.ParseCapabilities(test.names...)
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestDomainsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		nums    []int
+		domains uint16
+	}{
+		{name: "none", nums: nil, domains: 0},
+		{name: "first", nums: []int{1}, domains: commands.Domain1},
+		{name: "last", nums: []int{16}, domains: commands.Domain16},
+		{name: "several", nums: []int{1, 5, 6}, domains: commands.Domain1 | commands.Domain5 | commands.Domain6},
+		{name: "all", nums: []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}, domains: 0xffff},
+	}
+
+	for _, test := range tests {
+		domains, err := commands.DomainsFromList(test.nums...)
+		if err != nil {
+			t.Errorf("%s: building domains failed: %v", test.name, err)
+			continue
+		}
+		if domains != test.domains {
+			t.Errorf("%s: built 0x%04x, expected 0x%04x", test.name, domains, test.domains)
+		}
+
+		nums := commands.ParseDomains(test.domains)
+		if !reflect.DeepEqual(nums, test.nums) {
+			t.Errorf("%s: parsed %v, expected %v", test.name, nums, test.nums)
+		}
+	}
+
+	for _, num := range []int{-1, 0, 17} {
+		if _, err := commands.DomainsFromList(num); err == nil {
+			t.Errorf("expected an error for domain %d", num)
+		}
+	}
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	nonce := bytes.Repeat([]byte{0x01}, commands.WrapNonceLength)
+
+	tests := []struct {
+		name  string
+		data  []byte
+		lines int
+	}{
+		{name: "short", data: []byte{0x02}, lines: 1},
+		// 13 + 35 bytes encode to exactly 64 characters
+		{name: "single full line", data: bytes.Repeat([]byte{0x02}, 35), lines: 1},
+		{name: "multiple lines", data: bytes.Repeat([]byte{0x02}, 500), lines: 11},
+	}
+
+	for _, test := range tests {
+		backup := (&commands.ExportWrappedResponse{Nonce: nonce, Data: test.data}).Backup()
+
+		lines := strings.Split(strings.TrimSuffix(string(backup), "\n"), "\n")
+		if len(lines) != test.lines {
+			t.Errorf("%s: backup has %d lines, expected %d", test.name, len(lines), test.lines)
+		}
+		for _, line := range lines {
+			if len(line) > 64 {
+				t.Errorf("%s: backup line is %d characters long", test.name, len(line))
+			}
+		}
+
+		parsed, err := commands.ParseBackup(backup)
+		if err != nil {
+			t.Errorf("%s: parsing backup failed: %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(parsed.Nonce, nonce) || !bytes.Equal(parsed.Data, test.data) {
+			t.Errorf("%s: parsed backup does not match", test.name)
+		}
+	}
+}
+
+func TestParseBackupInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		backup []byte
+	}{
+		{name: "empty", backup: nil},
+		{name: "invalid base64", backup: []byte("not base64!\n")},
+		{name: "nonce only", backup: (&commands.ExportWrappedResponse{Nonce: make([]byte, commands.WrapNonceLength)}).Backup()},
+	}
+
+	for _, test := range tests {
+		_, err := commands.ParseBackup(test.backup)
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestSerializeResponseRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		response commands.Response
+	}{
+		{
+			name:     "error",
+			response: &commands.Error{Code: commands.ErrorCodeInvalidData},
+		},
+		{
+			name: "create session",
+			response: &commands.CreateSessionResponse{
+				SessionID:      3,
+				CardChallenge:  bytes.Repeat([]byte{0x01}, 8),
+				CardCryptogram: bytes.Repeat([]byte{0x02}, 8),
+			},
+		},
+		{
+			name: "session message",
+			response: &commands.SessionMessageResponse{
+				SessionID:     5,
+				EncryptedData: bytes.Repeat([]byte{0x03}, 32),
+				MAC:           bytes.Repeat([]byte{0x04}, 8),
+			},
+		},
+		{
+			name: "device info",
+			response: &commands.DeviceInfoResponse{
+				MajorVersion:        2,
+				MinorVersion:        3,
+				BuildVersion:        1,
+				SerialNumber:        12345678,
+				LogTotal:            62,
+				LogUsed:             4,
+				SupportedAlgorithms: []commands.Algorithm{commands.AlgorithmP256, commands.AlgorithmED25519},
+			},
+		},
+		{
+			name:     "echo",
+			response: &commands.EchoResponse{Data: []byte("echo")},
+		},
+		{
+			name:     "put asymmetric key",
+			response: &commands.PutAsymmetricKeyResponse{KeyID: 0x1234},
+		},
+		{
+			name: "export wrapped",
+			response: &commands.ExportWrappedResponse{
+				Nonce: bytes.Repeat([]byte{0x05}, commands.WrapNonceLength),
+				Data:  bytes.Repeat([]byte{0x06}, 20),
+			},
+		},
+		{
+			name:     "verify hmac",
+			response: &commands.VerifyHMACResponse{Verified: true},
+		},
+	}
+
+	for _, test := range tests {
+		data, err := commands.SerializeResponse(test.response)
+		if err != nil {
+			t.Errorf("%s: serializing failed: %v", test.name, err)
+			continue
+		}
+
+		parsed, err := commands.ParseResponse(data)
+		// Error responses are returned as error by ParseResponse
+		if errResponse, ok := err.(*commands.Error); ok {
+			parsed, err = errResponse, nil
+		}
+		if err != nil {
+			t.Errorf("%s: parsing failed: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(parsed, test.response) {
+			t.Errorf("%s: parsed %#v, expected %#v", test.name, parsed, test.response)
+		}
+	}
+}
+
+func TestParseGetLogsResponse(t *testing.T) {
+	entry := commands.LogEntry{
+		Index:      7,
+		Command:    commands.CommandTypeSignDataEcdsa,
+		Length:     0x0022,
+		SessionKey: 1,
+		TargetKey:  0x0100,
+		SecondKey:  0xffff,
+		Result:     byte(commands.CommandTypeSignDataEcdsa | commands.ResponseCommandOffset),
+		Systick:    0x01020304,
+		Digest:     [16]byte{0x0a, 0x0b, 0x0c},
+	}
+	encodedEntry := new(bytes.Buffer)
+	binary.Write(encodedEntry, binary.BigEndian, entry)
+	if encodedEntry.Len() != 32 {
+		t.Fatalf("log entries are %d bytes long, expected 32", encodedEntry.Len())
+	}
+
+	tests := []struct {
+		name     string
+		payload  []byte
+		expected *commands.GetLogsResponse
+	}{
+		{
+			name:     "no entries",
+			payload:  []byte{0x00, 0x01, 0x00, 0x02, 0x00},
+			expected: &commands.GetLogsResponse{UnloggedBoot: 1, UnloggedAuth: 2, Entries: []commands.LogEntry{}},
+		},
+		{
+			name:     "two entries",
+			payload:  append(append([]byte{0x00, 0x00, 0x00, 0x00, 0x02}, encodedEntry.Bytes()...), encodedEntry.Bytes()...),
+			expected: &commands.GetLogsResponse{Entries: []commands.LogEntry{entry, entry}},
+		},
+		{
+			name:    "too short",
+			payload: []byte{0x00, 0x00, 0x00, 0x00},
+		},
+		{
+			name:    "missing entry",
+			payload: append([]byte{0x00, 0x00, 0x00, 0x00, 0x02}, encodedEntry.Bytes()...),
+		},
+		{
+			name:    "truncated entry",
+			payload: append([]byte{0x00, 0x00, 0x00, 0x00, 0x01}, encodedEntry.Bytes()[:31]...),
+		},
+	}
+
+	for _, test := range tests {
+		response, err := commands.ParseResponse(responseData(commands.CommandTypeGetLogs, test.payload))
+		if test.expected == nil {
+			var lengthErr *commands.PayloadLengthError
+			if !errors.As(err, &lengthErr) {
+				t.Errorf("%s: expected a payload length error, got %v", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: parsing failed: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(response, test.expected) {
+			t.Errorf("%s: parsed %#v, expected %#v", test.name, response, test.expected)
+		}
+	}
+}
+
+func TestParseStorageStatusResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  []byte
+		expected *commands.StorageStatusResponse
+	}{
+		{
+			name:    "valid",
+			payload: []byte{0x01, 0x00, 0x00, 0xfe, 0x04, 0x00, 0x00, 0xfa, 0x02, 0x00},
+			expected: &commands.StorageStatusResponse{
+				TotalRecords: 256,
+				FreeRecords:  254,
+				TotalPages:   1024,
+				FreePages:    250,
+				PageSize:     512,
+			},
+		},
+		{
+			name:    "too short",
+			payload: []byte{0x01, 0x00, 0x00, 0xfe, 0x04, 0x00, 0x00, 0xfa, 0x02},
+		},
+		{
+			name:    "too long",
+			payload: []byte{0x01, 0x00, 0x00, 0xfe, 0x04, 0x00, 0x00, 0xfa, 0x02, 0x00, 0x00},
+		},
+	}
+
+	for _, test := range tests {
+		response, err := commands.ParseResponse(responseData(commands.CommandTypeStorageStatus, test.payload))
+		if test.expected == nil {
+			var lengthErr *commands.PayloadLengthError
+			if !errors.As(err, &lengthErr) {
+				t.Errorf("%s: expected a payload length error, got %v", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: parsing failed: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(response, test.expected) {
+			t.Errorf("%s: parsed %#v, expected %#v", test.name, response, test.expected)
+		}
+	}
+}
+
+func TestCreateSignDataPssCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		mgf1Algo commands.Algorithm
+		digest   []byte
+		valid    bool
+	}{
+		{name: "matching hash", mgf1Algo: commands.AlgorithmRSAMGF1SHA256, digest: make([]byte, 32), valid: true},
+		{name: "shorter digest", mgf1Algo: commands.AlgorithmRSAMGF1SHA256, digest: make([]byte, 20), valid: true},
+		{name: "longer digest", mgf1Algo: commands.AlgorithmRSAMGF1SHA1, digest: make([]byte, 64), valid: true},
+		{name: "empty digest", mgf1Algo: commands.AlgorithmRSAMGF1SHA256, digest: nil},
+		{name: "too long digest", mgf1Algo: commands.AlgorithmRSAMGF1SHA512, digest: make([]byte, 65)},
+		{name: "invalid mgf1 algorithm", mgf1Algo: commands.AlgorithmP256, digest: make([]byte, 32)},
+	}
+
+	for _, test := range tests {
+		command, err := commands.CreateSignDataPssCommand(1, test.mgf1Algo, 32, test.digest)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: creating command failed: %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(command.Data[5:], test.digest) {
+			t.Errorf("%s: command contains digest %x, expected %x", test.name, command.Data[5:], test.digest)
+		}
+	}
+}
+
+// responseData encodes payload as a response to commandType
+func responseData(commandType commands.CommandType, payload []byte) []byte {
+	data := []byte{byte(commandType | commands.ResponseCommandOffset), 0, 0}
+	binary.BigEndian.PutUint16(data[1:], uint16(len(payload)))
+
+	return append(data, payload...)
+}
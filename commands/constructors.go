@@ -2,13 +2,37 @@ package commands
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha1"   // register SHA1 for the MGF1 algorithms
+	_ "crypto/sha256" // register SHA256 for the MGF1 algorithms
+	_ "crypto/sha512" // register SHA384 and SHA512 for the MGF1 algorithms
+	"crypto/x509"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"math/big"
 
 	"github.com/certusone/yubihsm-go/authkey"
 )
 
+const (
+	// capabilitiesAsymmetricOperations are the capabilities that only apply to specific asymmetric key types
+	capabilitiesAsymmetricOperations = CapabilityAsymmetricSignPkcs | CapabilityAsymmetricSignPss |
+		CapabilityAsymmetricSignEcdsa | CapabilityAsymmetricSignEddsa |
+		CapabilityAsymmetricDecryptPkcs | CapabilityAsymmetricDecryptOaep |
+		CapabilityAsymmetricDeriveEcdh
+
+	capabilitiesEC      = CapabilityAsymmetricSignEcdsa | CapabilityAsymmetricDeriveEcdh
+	capabilitiesEd25519 = CapabilityAsymmetricSignEddsa
+	capabilitiesRSA     = CapabilityAsymmetricSignPkcs | CapabilityAsymmetricSignPss |
+		CapabilityAsymmetricDecryptPkcs | CapabilityAsymmetricDecryptOaep
+)
+
 
 func CreateDeviceInfoCommand() (*CommandMessage, error) {
 	command := &CommandMessage{
@@ -32,6 +56,36 @@ func CreateCreateSessionCommand(keySetID uint16, hostChallenge []byte) (*Command
 	return command, nil
 }
 
+// CreateCreateSessionAsymmetricCommand starts an asymmetrically authenticated session (SCP11) using the authentication
+// key keySetID. hostPublicKey is the uncompressed ephemeral P256 public key of the host.
+func CreateCreateSessionAsymmetricCommand(keySetID uint16, hostPublicKey []byte) (*CommandMessage, error) {
+	if len(hostPublicKey) != 65 || hostPublicKey[0] != 0x04 {
+		return nil, errors.New("invalid host public key; must be an uncompressed P256 point")
+	}
+
+	command := &CommandMessage{
+		CommandType: CommandTypeCreateSession,
+	}
+
+	payload := bytes.NewBuffer([]byte{})
+	binary.Write(payload, binary.BigEndian, keySetID)
+	payload.Write(hostPublicKey)
+
+	command.Data = payload.Bytes()
+
+	return command, nil
+}
+
+// CreateGetDevicePublicKeyCommand requests the static public key of the device that is used for asymmetric
+// authentication. It can be sent without a session.
+func CreateGetDevicePublicKeyCommand() (*CommandMessage, error) {
+	command := &CommandMessage{
+		CommandType: CommandTypeGetDevicePublicKey,
+	}
+
+	return command, nil
+}
+
 func CreateAuthenticateSessionCommand(hostCryptogram []byte) (*CommandMessage, error) {
 	command := &CommandMessage{
 		CommandType: CommandTypeAuthenticateSession,
@@ -55,6 +109,9 @@ func CreateGenerateAsymmetricKeyCommand(keyID uint16, label []byte, domains uint
 	if len(label) > LabelLength {
 		return nil, errors.New("label is too long")
 	}
+	if err := validateAsymmetricCapabilities(algorithm, capabilities); err != nil {
+		return nil, err
+	}
 	if len(label) < LabelLength {
 		label = append(label, bytes.Repeat([]byte{0x00}, LabelLength-len(label))...)
 	}
@@ -75,6 +132,8 @@ func CreateGenerateAsymmetricKeyCommand(keyID uint16, label []byte, domains uint
 	return command, nil
 }
 
+// CreateSignDataEddsaCommand signs data using Ed25519. data is the message itself and must not be hashed, since
+// Ed25519 hashes the message as part of the signature.
 func CreateSignDataEddsaCommand(keyID uint16, data []byte) (*CommandMessage, error) {
 	command := &CommandMessage{
 		CommandType: CommandTypeSignDataEddsa,
@@ -89,7 +148,40 @@ func CreateSignDataEddsaCommand(keyID uint16, data []byte) (*CommandMessage, err
 	return command, nil
 }
 
+// ecdsaDigestLengths maps the EC algorithms to the digest length matching the size of their curve.
+// P521 signs SHA-512 digests since there is no standard hash function matching its size.
+var ecdsaDigestLengths = map[Algorithm]int{
+	AlgorithmP224:      28,
+	AlgorithmP256:      32,
+	AlgorithmP384:      48,
+	AlgorithmP521:      64,
+	AlgorithmSecp256k1: 32,
+	AlgorithmECBP256:   32,
+	AlgorithmECBP384:   48,
+	AlgorithmECBP512:   64,
+}
+
+// ECDSADigestLength returns the recommended digest length for ECDSA signatures using a key of algorithm
+func ECDSADigestLength(algorithm Algorithm) (int, error) {
+	length, ok := ecdsaDigestLengths[algorithm]
+	if !ok {
+		return 0, fmt.Errorf("algorithm %s is not an ecdsa key algorithm", algorithm)
+	}
+
+	return length, nil
+}
+
+// maxEcdsaDigestLength is the byte length of the order of P521, the largest supported curve
+const maxEcdsaDigestLength = 66
+
+// CreateSignDataEcdsaCommand signs data using ECDSA. data must be the digest of the message and not the message
+// itself. Any digest of 1 to 66 bytes (the size of P521) is accepted since the curve of the key is not known here;
+// ECDSADigestLength returns the recommended length for a key algorithm.
 func CreateSignDataEcdsaCommand(keyID uint16, data []byte) (*CommandMessage, error) {
+	if len(data) == 0 || len(data) > maxEcdsaDigestLength {
+		return nil, fmt.Errorf("invalid ecdsa digest length %d; expected 1 to %d bytes", len(data), maxEcdsaDigestLength)
+	}
+
 	command := &CommandMessage{
 		CommandType: CommandTypeSignDataEcdsa,
 	}
@@ -103,7 +195,17 @@ func CreateSignDataEcdsaCommand(keyID uint16, data []byte) (*CommandMessage, err
 	return command, nil
 }
 
+// maxPkcs1DigestLength is the length of a SHA-512 digest, the largest digest supported by PKCS#1 v1.5 signatures
+const maxPkcs1DigestLength = 64
+
+// CreateSignDataPkcs1Command signs data using RSASSA-PKCS1-v1_5. data must be the SHA-1, SHA-256, SHA-384 or SHA-512
+// digest of the message and not the message itself; the HSM selects the DigestInfo prefix by its length. Only empty
+// digests and digests longer than 64 bytes are rejected here.
 func CreateSignDataPkcs1Command(keyID uint16, data []byte) (*CommandMessage, error) {
+	if len(data) == 0 || len(data) > maxPkcs1DigestLength {
+		return nil, fmt.Errorf("invalid pkcs1 digest length %d; expected 1 to %d bytes", len(data), maxPkcs1DigestLength)
+	}
+
 	command := &CommandMessage{
 		CommandType: CommandTypeSignDataPkcs1,
 	}
@@ -117,10 +219,71 @@ func CreateSignDataPkcs1Command(keyID uint16, data []byte) (*CommandMessage, err
 	return command, nil
 }
 
+// maxPssDigestLength is the length of a SHA-512 digest, the largest digest supported by RSA-PSS signatures
+const maxPssDigestLength = 64
+
+// CreateSignDataPssCommand signs a digest using RSA-PSS. data must be the digest of the message, usually hashed using
+// the hash function of mgf1Algo. saltLen is the length of the salt in bytes. The message hash may differ from the MGF1
+// hash, so only empty digests and digests longer than 64 bytes are rejected here.
+func CreateSignDataPssCommand(keyID uint16, mgf1Algo Algorithm, saltLen uint16, data []byte) (*CommandMessage, error) {
+	if _, err := mgf1Hash(mgf1Algo); err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || len(data) > maxPssDigestLength {
+		return nil, fmt.Errorf("invalid pss digest length %d; expected 1 to %d bytes", len(data), maxPssDigestLength)
+	}
+
+	command := &CommandMessage{
+		CommandType: CommandTypeSignDataPss,
+	}
+
+	payload := bytes.NewBuffer([]byte{})
+	binary.Write(payload, binary.BigEndian, keyID)
+	binary.Write(payload, binary.BigEndian, mgf1Algo)
+	binary.Write(payload, binary.BigEndian, saltLen)
+	payload.Write(data)
+
+	command.Data = payload.Bytes()
+
+	return command, nil
+}
+
+// CreateDecryptOaepCommand decrypts data using RSA-OAEP. label must be the hash of the OAEP label as returned by
+// HashOaepLabel, computed with the hash function of mgf1Algo.
+func CreateDecryptOaepCommand(keyID uint16, mgf1Algo Algorithm, label []byte, data []byte) (*CommandMessage, error) {
+	hash, err := mgf1Hash(mgf1Algo)
+	if err != nil {
+		return nil, err
+	}
+	if len(label) != hash.Size() {
+		return nil, errors.New("invalid label hash length")
+	}
+
+	command := &CommandMessage{
+		CommandType: CommandTypeDecryptOaep,
+	}
+
+	payload := bytes.NewBuffer([]byte{})
+	binary.Write(payload, binary.BigEndian, keyID)
+	binary.Write(payload, binary.BigEndian, mgf1Algo)
+	payload.Write(data)
+	payload.Write(label)
+
+	command.Data = payload.Bytes()
+
+	return command, nil
+}
+
 func CreatePutAsymmetricKeyCommand(keyID uint16, label []byte, domains uint16, capabilities uint64, algorithm Algorithm, keyPart1 []byte, keyPart2 []byte) (*CommandMessage, error) {
 	if len(label) > LabelLength {
 		return nil, errors.New("label is too long")
 	}
+	if err := validateAsymmetricCapabilities(algorithm, capabilities); err != nil {
+		return nil, err
+	}
+	if err := validateAsymmetricKeyParts(algorithm, keyPart1, keyPart2); err != nil {
+		return nil, err
+	}
 	if len(label) < LabelLength {
 		label = append(label, bytes.Repeat([]byte{0x00}, LabelLength-len(label))...)
 	}
@@ -144,6 +307,64 @@ func CreatePutAsymmetricKeyCommand(keyID uint16, label []byte, domains uint16, c
 	return command, nil
 }
 
+// CreatePutRSAKeyCommand stores an RSA private key. The algorithm is selected from the modulus size and the primes
+// are passed to the HSM as the two key parts. The HSM always uses the public exponent 65537, so keys with a different
+// exponent are rejected.
+func CreatePutRSAKeyCommand(keyID uint16, label []byte, domains uint16, capabilities uint64, key *rsa.PrivateKey) (*CommandMessage, error) {
+	if len(key.Primes) != 2 {
+		return nil, errors.New("multi-prime RSA keys are not supported")
+	}
+	if key.E != rsaPublicExponent {
+		return nil, fmt.Errorf("unsupported RSA public exponent %d; the HSM only supports %d", key.E, rsaPublicExponent)
+	}
+
+	var algorithm Algorithm
+	switch key.N.BitLen() {
+	case 2048:
+		algorithm = AlgorithmRSA2048
+	case 3072:
+		algorithm = AlgorithmRSA3072
+	case 4096:
+		algorithm = AlgorithmRSA4096
+	default:
+		return nil, fmt.Errorf("unsupported RSA key size of %d bits", key.N.BitLen())
+	}
+
+	primeLength := key.N.BitLen() / 16
+	return CreatePutAsymmetricKeyCommand(keyID, label, domains, capabilities, algorithm,
+		padBigInt(key.Primes[0], primeLength), padBigInt(key.Primes[1], primeLength))
+}
+
+// CreatePutECKeyCommand stores an EC private key. The algorithm is selected from the curve of the key and the private
+// scalar is passed to the HSM padded to the curve size. Only the curves supported by crypto/elliptic are available.
+func CreatePutECKeyCommand(keyID uint16, label []byte, domains uint16, capabilities uint64, key *ecdsa.PrivateKey) (*CommandMessage, error) {
+	var algorithm Algorithm
+	switch key.Curve {
+	case elliptic.P224():
+		algorithm = AlgorithmP224
+	case elliptic.P256():
+		algorithm = AlgorithmP256
+	case elliptic.P384():
+		algorithm = AlgorithmP384
+	case elliptic.P521():
+		algorithm = AlgorithmP521
+	default:
+		return nil, errors.New("unsupported curve")
+	}
+
+	return CreatePutAsymmetricKeyCommand(keyID, label, domains, capabilities, algorithm,
+		padBigInt(key.D, (key.Curve.Params().BitSize+7)/8), nil)
+}
+
+// CreatePutEd25519KeyCommand stores an Ed25519 private key. The HSM expects the 32 byte seed of the key.
+func CreatePutEd25519KeyCommand(keyID uint16, label []byte, domains uint16, capabilities uint64, key ed25519.PrivateKey) (*CommandMessage, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid ed25519 private key length")
+	}
+
+	return CreatePutAsymmetricKeyCommand(keyID, label, domains, capabilities, AlgorithmED25519, key.Seed(), nil)
+}
+
 type ListCommandOption func(w io.Writer)
 
 func NewObjectTypeOption(objectType uint8) ListCommandOption {
@@ -167,6 +388,20 @@ func NewDomainOption(domain uint16) ListCommandOption {
 	}
 }
 
+func NewCapabilityOption(capabilities uint64) ListCommandOption {
+	return func(w io.Writer) {
+		binary.Write(w, binary.BigEndian, ListObjectParamCapabilities)
+		binary.Write(w, binary.BigEndian, capabilities)
+	}
+}
+
+func NewAlgorithmOption(algorithm Algorithm) ListCommandOption {
+	return func(w io.Writer) {
+		binary.Write(w, binary.BigEndian, ListObjectParamAlgorithm)
+		binary.Write(w, binary.BigEndian, algorithm)
+	}
+}
+
 func NewLabelOption(label []byte) (ListCommandOption, error) {
 	if len(label) > LabelLength {
 		return nil, errors.New("label is too long")
@@ -265,16 +500,29 @@ func CreateDeriveEcdhCommand(objID uint16, pubkey []byte) (*CommandMessage, erro
 }
 
 func CreateChangeAuthenticationKeyCommand(objID uint16, newPassword string) (*CommandMessage, error) {
+	authKey := authkey.NewFromPassword(newPassword)
+	return CreateChangeAuthenticationKeyRawCommand(objID, authKey.GetEncKey(), authKey.GetMacKey())
+}
+
+// CreateChangeAuthenticationKeyRawCommand changes an authentication key to the given raw encryption and MAC keys,
+// e.g. to rotate to randomly generated keys that are not derived from a password
+func CreateChangeAuthenticationKeyRawCommand(objID uint16, encKey, macKey []byte) (*CommandMessage, error) {
+	if len(encKey) != 16 {
+		return nil, errors.New("invalid encryption key length")
+	}
+	if len(macKey) != 16 {
+		return nil, errors.New("invalid mac key length")
+	}
+
 	command := &CommandMessage{
 		CommandType: CommandTypeChangeAuthenticationKey,
 	}
 
-	authKey := authkey.NewFromPassword(newPassword)
 	payload := bytes.NewBuffer([]byte{})
 	binary.Write(payload, binary.BigEndian, objID)
 	binary.Write(payload, binary.BigEndian, AlgorithmYubicoAESAuthentication)
-	payload.Write(authKey.GetEncKey())
-	payload.Write(authKey.GetMacKey())
+	payload.Write(encKey)
+	payload.Write(macKey)
 	command.Data = payload.Bytes()
 
 	return command, nil
@@ -305,6 +553,15 @@ func CreatePutOpaqueCommand(objID uint16, label []byte, domains uint16, capabili
 	return command, nil
 }
 
+// CreatePutOpaqueCertificateCommand stores an X.509 certificate as an opaque object using AlgorithmOpaqueX509Certificate
+func CreatePutOpaqueCertificateCommand(objID uint16, label []byte, domains uint16, capabilities uint64, cert *x509.Certificate) (*CommandMessage, error) {
+	if cert == nil || len(cert.Raw) == 0 {
+		return nil, errors.New("certificate is empty")
+	}
+
+	return CreatePutOpaqueCommand(objID, label, domains, capabilities, AlgorithmOpaqueX509Certificate, cert.Raw)
+}
+
 func CreateGetOpaqueCommand(objID uint16) (*CommandMessage, error) {
 	command := &CommandMessage{
 		CommandType: CommandTypeGetOpaque,
@@ -379,8 +636,7 @@ func CreatePutAuthkeyCommand(objID uint16, label []byte, domains uint16, capabil
 		label = append(label, bytes.Repeat([]byte{0x00}, LabelLength-len(label))...)
 	}
 	algorithm := AlgorithmYubicoAESAuthentication
-	// TODO: support P256 Authentication when it is released
-	// https://github.com/Yubico/yubihsm-shell/blob/1c8e254603e72f3f39cf1c3910996dbfcdba2b12/lib/yubihsm.c#L3110
+	// P256 authentication keys are stored using CreatePutAsymmetricAuthKeyCommand
 	if len(encKey) != 16 {
 		return nil, errors.New("invalid encryption key length")
 	}
@@ -407,6 +663,38 @@ func CreatePutAuthkeyCommand(objID uint16, label []byte, domains uint16, capabil
 	return command, nil
 }
 
+// CreatePutAsymmetricAuthKeyCommand stores an asymmetric authentication key. The HSM only stores the public key of
+// the host; the private key is used with securechannel.NewSecureChannelAsymmetric to authenticate.
+func CreatePutAsymmetricAuthKeyCommand(objID uint16, label []byte, domains uint16, capabilities, delegated uint64, publicKey *ecdsa.PublicKey) (*CommandMessage, error) {
+	if len(label) > LabelLength {
+		return nil, errors.New("label is too long")
+	}
+	if len(label) < LabelLength {
+		label = append(label, bytes.Repeat([]byte{0x00}, LabelLength-len(label))...)
+	}
+	if publicKey.Curve != elliptic.P256() {
+		return nil, errors.New("asymmetric authentication keys must use the P256 curve")
+	}
+
+	command := &CommandMessage{
+		CommandType: CommandTypePutAuthKey,
+	}
+
+	payload := bytes.NewBuffer([]byte{})
+	binary.Write(payload, binary.BigEndian, objID)
+	payload.Write(label)
+	binary.Write(payload, binary.BigEndian, domains)
+	binary.Write(payload, binary.BigEndian, capabilities)
+	binary.Write(payload, binary.BigEndian, AlgorithmP256Authentication)
+	binary.Write(payload, binary.BigEndian, delegated)
+	// The point is stored without the uncompressed point marker
+	payload.Write(elliptic.Marshal(publicKey.Curve, publicKey.X, publicKey.Y)[1:])
+
+	command.Data = payload.Bytes()
+
+	return command, nil
+}
+
 func CreatePutDerivedAuthenticationKeyCommand(objID uint16, label []byte, domains uint16, capabilities uint64, delegated uint64, password string) (*CommandMessage, error) {
 	authKey := authkey.NewFromPassword(password)
 	return CreatePutAuthkeyCommand(objID, label, domains, capabilities, delegated, authKey.GetEncKey(), authKey.GetMacKey())
@@ -447,7 +735,40 @@ func CreateImportWrappedCommand(wrapObjID uint16, nonce, data []byte) (*CommandM
 	command := &CommandMessage{
 		CommandType: CommandTypeImportWrapped,
 	}
-	if len(nonce) != 13 {
+	if len(nonce) != WrapNonceLength {
+		return nil, errors.New("invalid nonce length")
+	}
+
+	payload := bytes.NewBuffer([]byte{})
+	binary.Write(payload, binary.BigEndian, wrapObjID)
+	payload.Write(nonce)
+	payload.Write(data)
+	command.Data = payload.Bytes()
+
+	return command, nil
+}
+
+// CreateWrapDataCommand encrypts data using the wrap key wrapObjID with AES-CCM. The response contains the random
+// nonce chosen by the HSM and the ciphertext including the MAC.
+func CreateWrapDataCommand(wrapObjID uint16, data []byte) (*CommandMessage, error) {
+	command := &CommandMessage{
+		CommandType: CommandTypeWrapData,
+	}
+
+	payload := bytes.NewBuffer([]byte{})
+	binary.Write(payload, binary.BigEndian, wrapObjID)
+	payload.Write(data)
+	command.Data = payload.Bytes()
+
+	return command, nil
+}
+
+// CreateUnwrapDataCommand decrypts data that was encrypted using CreateWrapDataCommand with the wrap key wrapObjID
+func CreateUnwrapDataCommand(wrapObjID uint16, nonce, data []byte) (*CommandMessage, error) {
+	command := &CommandMessage{
+		CommandType: CommandTypeUnwrapData,
+	}
+	if len(nonce) != WrapNonceLength {
 		return nil, errors.New("invalid nonce length")
 	}
 
@@ -459,3 +780,337 @@ func CreateImportWrappedCommand(wrapObjID uint16, nonce, data []byte) (*CommandM
 
 	return command, nil
 }
+
+// HashOaepLabel returns the hash of an OAEP label as expected by the DecryptOaep command.
+// The HSM does not take the label itself but its hash, computed using the hash function of the MGF1 algorithm
+// used for decryption. An empty label must still be hashed.
+func HashOaepLabel(label []byte, mgf1Algo Algorithm) ([]byte, error) {
+	hash, err := mgf1Hash(mgf1Algo)
+	if err != nil {
+		return nil, err
+	}
+
+	h := hash.New()
+	h.Write(label)
+
+	return h.Sum(nil), nil
+}
+
+// mgf1Hash returns the hash function used by an MGF1 algorithm
+func mgf1Hash(mgf1Algo Algorithm) (crypto.Hash, error) {
+	switch mgf1Algo {
+	case AlgorithmRSAMGF1SHA1:
+		return crypto.SHA1, nil
+	case AlgorithmRSAMGF1SHA256:
+		return crypto.SHA256, nil
+	case AlgorithmRSAMGF1SHA384:
+		return crypto.SHA384, nil
+	case AlgorithmRSAMGF1SHA512:
+		return crypto.SHA512, nil
+	default:
+		return 0, errors.New("invalid mgf1 algorithm")
+	}
+}
+
+func CreateHMACDataCommand(keyID uint16, data []byte) (*CommandMessage, error) {
+	command := &CommandMessage{
+		CommandType: CommandTypeHMACData,
+	}
+
+	payload := bytes.NewBuffer([]byte{})
+	binary.Write(payload, binary.BigEndian, keyID)
+	payload.Write(data)
+
+	command.Data = payload.Bytes()
+
+	return command, nil
+}
+
+func CreateVerifyHMACCommand(keyID uint16, mac []byte, data []byte) (*CommandMessage, error) {
+	command := &CommandMessage{
+		CommandType: CommandTypeVerifyHMAC,
+	}
+
+	payload := bytes.NewBuffer([]byte{})
+	binary.Write(payload, binary.BigEndian, keyID)
+	payload.Write(mac)
+	payload.Write(data)
+
+	command.Data = payload.Bytes()
+
+	return command, nil
+}
+
+func CreateGetLogsCommand() (*CommandMessage, error) {
+	command := &CommandMessage{
+		CommandType: CommandTypeGetLogs,
+	}
+
+	return command, nil
+}
+
+func CreateStorageStatusCommand() (*CommandMessage, error) {
+	command := &CommandMessage{
+		CommandType: CommandTypeStorageStatus,
+	}
+
+	return command, nil
+}
+
+// CreateGetOptionCommand reads the value of a device option, e.g. OptionCommandAudit
+func CreateGetOptionCommand(option uint8) (*CommandMessage, error) {
+	command := &CommandMessage{
+		CommandType: CommandTypeGetOption,
+		Data:        []byte{option},
+	}
+
+	return command, nil
+}
+
+func CreateSetBlinkCommand(seconds uint8) (*CommandMessage, error) {
+	command := &CommandMessage{
+		CommandType: CommandTypeSetBlink,
+		Data:        []byte{seconds},
+	}
+
+	return command, nil
+}
+
+// CreateSignSSHCertificateCommand signs an SSH certificate request using the key keyID and the template templateID.
+// signature is the signature over timestamp that authorizes the request and request is the certificate to be signed.
+func CreateSignSSHCertificateCommand(keyID uint16, templateID uint16, algorithm Algorithm, timestamp uint32, signature []byte, request []byte) (*CommandMessage, error) {
+	command := &CommandMessage{
+		CommandType: CommandTypeSshCertify,
+	}
+
+	payload := bytes.NewBuffer([]byte{})
+	binary.Write(payload, binary.BigEndian, keyID)
+	binary.Write(payload, binary.BigEndian, templateID)
+	binary.Write(payload, binary.BigEndian, algorithm)
+	binary.Write(payload, binary.BigEndian, timestamp)
+	payload.Write(signature)
+	payload.Write(request)
+
+	command.Data = payload.Bytes()
+
+	return command, nil
+}
+
+// CreatePutOTPAeadKeyCommand stores an OTP AEAD key. algorithm must be one of AlgorithmAES128YUBICOOTP,
+// AlgorithmAES192YUBICOOTP or AlgorithmAES256YUBICOOTP and key must have the matching length.
+func CreatePutOTPAeadKeyCommand(objID uint16, label []byte, domains uint16, capabilities uint64, algorithm Algorithm, nonceID uint32, key []byte) (*CommandMessage, error) {
+	if len(label) > LabelLength {
+		return nil, errors.New("label is too long")
+	}
+	if len(label) < LabelLength {
+		label = append(label, bytes.Repeat([]byte{0x00}, LabelLength-len(label))...)
+	}
+	switch algorithm {
+	case AlgorithmAES128YUBICOOTP:
+		if keyLen := len(key); keyLen != 16 {
+			return nil, errors.New("key is wrong length")
+		}
+	case AlgorithmAES192YUBICOOTP:
+		if keyLen := len(key); keyLen != 24 {
+			return nil, errors.New("key is wrong length")
+		}
+	case AlgorithmAES256YUBICOOTP:
+		if keyLen := len(key); keyLen != 32 {
+			return nil, errors.New("key is wrong length")
+		}
+	default:
+		return nil, errors.New("invalid algorithm")
+	}
+
+	command := &CommandMessage{
+		CommandType: CommandTypePutOTPAeadKey,
+	}
+
+	payload := bytes.NewBuffer([]byte{})
+	binary.Write(payload, binary.BigEndian, objID)
+	payload.Write(label)
+	binary.Write(payload, binary.BigEndian, domains)
+	binary.Write(payload, binary.BigEndian, capabilities)
+	binary.Write(payload, binary.BigEndian, algorithm)
+	binary.Write(payload, binary.BigEndian, nonceID)
+	payload.Write(key)
+
+	command.Data = payload.Bytes()
+
+	return command, nil
+}
+
+// CreateGenerateOTPAeadKeyCommand generates an OTP AEAD key on the HSM. algorithm must be one of
+// AlgorithmAES128YUBICOOTP, AlgorithmAES192YUBICOOTP or AlgorithmAES256YUBICOOTP.
+func CreateGenerateOTPAeadKeyCommand(objID uint16, label []byte, domains uint16, capabilities uint64, algorithm Algorithm, nonceID uint32) (*CommandMessage, error) {
+	if len(label) > LabelLength {
+		return nil, errors.New("label is too long")
+	}
+	if len(label) < LabelLength {
+		label = append(label, bytes.Repeat([]byte{0x00}, LabelLength-len(label))...)
+	}
+	switch algorithm {
+	case AlgorithmAES128YUBICOOTP, AlgorithmAES192YUBICOOTP, AlgorithmAES256YUBICOOTP:
+	default:
+		return nil, errors.New("invalid algorithm")
+	}
+
+	command := &CommandMessage{
+		CommandType: CommandTypeGenerateOTPAeadKey,
+	}
+
+	payload := bytes.NewBuffer([]byte{})
+	binary.Write(payload, binary.BigEndian, objID)
+	payload.Write(label)
+	binary.Write(payload, binary.BigEndian, domains)
+	binary.Write(payload, binary.BigEndian, capabilities)
+	binary.Write(payload, binary.BigEndian, algorithm)
+	binary.Write(payload, binary.BigEndian, nonceID)
+
+	command.Data = payload.Bytes()
+
+	return command, nil
+}
+
+// CreateOTPDecryptCommand decrypts a Yubico OTP using the AEAD that was created for the OTP's key.
+func CreateOTPDecryptCommand(keyID uint16, aead []byte, otp []byte) (*CommandMessage, error) {
+	if len(otp) != 16 {
+		return nil, errors.New("invalid otp length")
+	}
+
+	command := &CommandMessage{
+		CommandType: CommandTypeOTPDecrypt,
+	}
+
+	payload := bytes.NewBuffer([]byte{})
+	binary.Write(payload, binary.BigEndian, keyID)
+	payload.Write(aead)
+	payload.Write(otp)
+
+	command.Data = payload.Bytes()
+
+	return command, nil
+}
+
+// CreateOTPAeadCreateCommand creates an AEAD from the AES key and private ID of a Yubico OTP credential.
+func CreateOTPAeadCreateCommand(keyID uint16, key []byte, privateID []byte) (*CommandMessage, error) {
+	if len(key) != 16 {
+		return nil, errors.New("invalid key length")
+	}
+	if len(privateID) != 6 {
+		return nil, errors.New("invalid private id length")
+	}
+
+	command := &CommandMessage{
+		CommandType: CommandTypeOTPAeadCreate,
+	}
+
+	payload := bytes.NewBuffer([]byte{})
+	binary.Write(payload, binary.BigEndian, keyID)
+	payload.Write(key)
+	payload.Write(privateID)
+
+	command.Data = payload.Bytes()
+
+	return command, nil
+}
+
+// CreateOTPAeadRandomCommand creates an AEAD from random OTP key material generated on the HSM.
+func CreateOTPAeadRandomCommand(keyID uint16) (*CommandMessage, error) {
+	command := &CommandMessage{
+		CommandType: CommandTypeOTPAeadRandom,
+	}
+
+	payload := bytes.NewBuffer([]byte{})
+	binary.Write(payload, binary.BigEndian, keyID)
+
+	command.Data = payload.Bytes()
+
+	return command, nil
+}
+
+// CreateOTPAeadRewrapCommand re-encrypts an AEAD created with the OTP AEAD key fromKeyID under the key toKeyID.
+func CreateOTPAeadRewrapCommand(fromKeyID uint16, toKeyID uint16, aead []byte) (*CommandMessage, error) {
+	command := &CommandMessage{
+		CommandType: CommandTypeOTPAeadRewrap,
+	}
+
+	payload := bytes.NewBuffer([]byte{})
+	binary.Write(payload, binary.BigEndian, fromKeyID)
+	binary.Write(payload, binary.BigEndian, toKeyID)
+	payload.Write(aead)
+
+	command.Data = payload.Bytes()
+
+	return command, nil
+}
+
+// validateAsymmetricCapabilities checks that the asymmetric operation capabilities are supported by the key
+// algorithm. The HSM only responds with ErrorCodeInvalidData in that case.
+func validateAsymmetricCapabilities(algorithm Algorithm, capabilities uint64) error {
+	var allowed uint64
+	switch algorithm {
+	case AlgorithmP224, AlgorithmP256, AlgorithmP384, AlgorithmP521, AlgorithmSecp256k1,
+		AlgorithmECBP256, AlgorithmECBP384, AlgorithmECBP512:
+		allowed = capabilitiesEC
+	case AlgorithmED25519:
+		allowed = capabilitiesEd25519
+	case AlgorithmRSA2048, AlgorithmRSA3072, AlgorithmRSA4096:
+		allowed = capabilitiesRSA
+	default:
+		return fmt.Errorf("algorithm %s is not an asymmetric key algorithm", algorithm)
+	}
+
+	if invalid := capabilities & capabilitiesAsymmetricOperations &^ allowed; invalid != 0 {
+		return fmt.Errorf("capabilities 0x%016x are not supported by algorithm %s", invalid, algorithm)
+	}
+
+	return nil
+}
+
+// asymmetricKeyPartLengths maps the asymmetric algorithms to the length of their private key parts. EC keys consist of
+// the private scalar and Ed25519 keys of the seed. RSA keys consist of the two primes, each half the modulus size.
+var asymmetricKeyPartLengths = map[Algorithm]struct{ part1, part2 int }{
+	AlgorithmP224:      {28, 0},
+	AlgorithmP256:      {32, 0},
+	AlgorithmP384:      {48, 0},
+	AlgorithmP521:      {66, 0},
+	AlgorithmSecp256k1: {32, 0},
+	AlgorithmECBP256:   {32, 0},
+	AlgorithmECBP384:   {48, 0},
+	AlgorithmECBP512:   {64, 0},
+	AlgorithmED25519:   {32, 0},
+	AlgorithmRSA2048:   {128, 128},
+	AlgorithmRSA3072:   {192, 192},
+	AlgorithmRSA4096:   {256, 256},
+}
+
+// validateAsymmetricKeyParts checks that the key parts of a PutAsymmetricKey command have the lengths the HSM expects
+// for algorithm
+func validateAsymmetricKeyParts(algorithm Algorithm, keyPart1, keyPart2 []byte) error {
+	lengths, ok := asymmetricKeyPartLengths[algorithm]
+	if !ok {
+		return fmt.Errorf("algorithm %s is not an asymmetric key algorithm", algorithm)
+	}
+
+	if len(keyPart1) != lengths.part1 {
+		return fmt.Errorf("key part 1 is %d bytes long but algorithm %s expects %d bytes", len(keyPart1), algorithm, lengths.part1)
+	}
+	if len(keyPart2) != lengths.part2 {
+		return fmt.Errorf("key part 2 is %d bytes long but algorithm %s expects %d bytes", len(keyPart2), algorithm, lengths.part2)
+	}
+
+	return nil
+}
+
+// padBigInt returns the big-endian representation of n left-padded with zeros to length bytes
+func padBigInt(n *big.Int, length int) []byte {
+	b := n.Bytes()
+	if len(b) >= length {
+		return b
+	}
+
+	padded := make([]byte, length)
+	copy(padded[length-len(b):], b)
+	return padded
+}
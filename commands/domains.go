@@ -0,0 +1,29 @@
+package commands
+
+import "fmt"
+
+// DomainsFromList builds a domain bitmask from domain numbers between 1 and 16
+func DomainsFromList(nums ...int) (uint16, error) {
+	var domains uint16
+	for _, num := range nums {
+		if num < 1 || num > 16 {
+			return 0, fmt.Errorf("invalid domain %d; must be between 1 and 16", num)
+		}
+
+		domains |= 1 << uint(num-1)
+	}
+
+	return domains, nil
+}
+
+// ParseDomains returns the domain numbers contained in a domain bitmask in ascending order
+func ParseDomains(domains uint16) []int {
+	var nums []int
+	for num := 1; num <= 16; num++ {
+		if domains&(1<<uint(num-1)) != 0 {
+			nums = append(nums, num)
+		}
+	}
+
+	return nums
+}
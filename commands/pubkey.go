@@ -0,0 +1,134 @@
+package commands
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"math/big"
+)
+
+// rsaPublicExponent is the public exponent used by the HSM for all RSA keys
+const rsaPublicExponent = 65537
+
+// ECDSA returns the public key of an EC key. KeyData contains the concatenated X and Y coordinates.
+// Only the curves supported by crypto/elliptic are available.
+func (r *GetPubKeyResponse) ECDSA() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch r.Algorithm {
+	case AlgorithmP224:
+		curve = elliptic.P224()
+	case AlgorithmP256:
+		curve = elliptic.P256()
+	case AlgorithmP384:
+		curve = elliptic.P384()
+	case AlgorithmP521:
+		curve = elliptic.P521()
+	default:
+		return nil, errors.New("key is not an ecdsa key with a supported curve")
+	}
+
+	size := (curve.Params().BitSize + 7) / 8
+	if len(r.KeyData) != 2*size {
+		return nil, errors.New("invalid ecdsa public key length")
+	}
+
+	publicKey := &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(r.KeyData[:size]),
+		Y:     new(big.Int).SetBytes(r.KeyData[size:]),
+	}
+	if !curve.IsOnCurve(publicKey.X, publicKey.Y) {
+		return nil, errors.New("public key is not on the curve")
+	}
+
+	return publicKey, nil
+}
+
+// Ed25519 returns the public key of an Ed25519 key. KeyData contains the raw 32 byte public key.
+func (r *GetPubKeyResponse) Ed25519() (ed25519.PublicKey, error) {
+	if r.Algorithm != AlgorithmED25519 {
+		return nil, errors.New("key is not an ed25519 key")
+	}
+	if len(r.KeyData) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid ed25519 public key length")
+	}
+
+	return ed25519.PublicKey(r.KeyData), nil
+}
+
+// RSA returns the public key of an RSA key. KeyData contains the modulus.
+func (r *GetPubKeyResponse) RSA() (*rsa.PublicKey, error) {
+	var length int
+	switch r.Algorithm {
+	case AlgorithmRSA2048:
+		length = 256
+	case AlgorithmRSA3072:
+		length = 384
+	case AlgorithmRSA4096:
+		length = 512
+	default:
+		return nil, errors.New("key is not an rsa key")
+	}
+
+	if len(r.KeyData) != length {
+		return nil, errors.New("invalid rsa public key length")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(r.KeyData),
+		E: rsaPublicExponent,
+	}, nil
+}
+
+// PublicKey returns the public key as *ecdsa.PublicKey, ed25519.PublicKey or *rsa.PublicKey depending on the Algorithm.
+func (r *GetPubKeyResponse) PublicKey() (crypto.PublicKey, error) {
+	var publicKey crypto.PublicKey
+	var err error
+	switch r.Algorithm {
+	case AlgorithmP224, AlgorithmP256, AlgorithmP384, AlgorithmP521:
+		publicKey, err = r.ECDSA()
+	case AlgorithmED25519:
+		publicKey, err = r.Ed25519()
+	case AlgorithmRSA2048, AlgorithmRSA3072, AlgorithmRSA4096:
+		publicKey, err = r.RSA()
+	default:
+		return nil, errors.New("unsupported algorithm")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return publicKey, nil
+}
+
+// PEM returns the public key PKIX encoded in a "PUBLIC KEY" PEM block.
+func (r *GetPubKeyResponse) PEM() ([]byte, error) {
+	publicKey, err := r.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: der,
+	}), nil
+}
+
+// PublicKey returns the static P256 public key of the device used for asymmetric authentication
+func (r *GetDevicePublicKeyResponse) PublicKey() (*ecdsa.PublicKey, error) {
+	if r.Algorithm != AlgorithmP256Authentication && r.Algorithm != AlgorithmP256 {
+		return nil, errors.New("device public key has an unsupported algorithm")
+	}
+
+	return (&GetPubKeyResponse{Algorithm: AlgorithmP256, KeyData: r.KeyData}).ECDSA()
+}
@@ -2,9 +2,13 @@ package commands
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/asn1"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math/big"
 )
 
 type (
@@ -15,6 +19,17 @@ type (
 		Code ErrorCode
 	}
 
+	// PayloadLengthError is returned if the payload of a response has an unexpected length
+	PayloadLengthError struct {
+		CommandType CommandType
+		Constraint  LengthConstraint
+		Expected    int
+		Actual      int
+	}
+
+	// LengthConstraint describes how the Expected length of a PayloadLengthError is to be interpreted
+	LengthConstraint uint8
+
 	DeviceInfoResponse struct {
 		MajorVersion        uint8
 		MinorVersion        uint8
@@ -31,6 +46,20 @@ type (
 		CardCryptogram []byte
 	}
 
+	// CreateSessionAsymmetricResponse is the response to CreateSession for asymmetric authentication keys
+	CreateSessionAsymmetricResponse struct {
+		SessionID uint8
+		// CardPublicKey is the uncompressed ephemeral P256 public key of the device
+		CardPublicKey []byte
+		Receipt       []byte
+	}
+
+	GetDevicePublicKeyResponse struct {
+		Algorithm Algorithm
+		// KeyData contains the concatenated X and Y coordinates of the P256 public key
+		KeyData []byte
+	}
+
 	SessionMessageResponse struct {
 		SessionID     uint8
 		EncryptedData []byte
@@ -80,6 +109,14 @@ type (
 		Signature []byte
 	}
 
+	SignDataPssResponse struct {
+		Signature []byte
+	}
+
+	DecryptOaepResponse struct {
+		Decrypted []byte
+	}
+
 	GetPubKeyResponse struct {
 		Algorithm Algorithm
 		// KeyData can contain different formats depending on the algorithm according to the YubiHSM2 documentation.
@@ -127,6 +164,120 @@ type (
 		ObjectType uint8
 		ObjectID   uint16
 	}
+
+	WrapDataResponse struct {
+		Nonce []byte
+		Data  []byte
+	}
+
+	UnwrapDataResponse struct {
+		Data []byte
+	}
+
+	GetPseudoRandomResponse struct {
+		Data []byte
+	}
+
+	OTPAeadCreateResponse struct {
+		AEAD []byte
+	}
+
+	OTPAeadRandomResponse struct {
+		AEAD []byte
+	}
+
+	OTPAeadRewrapResponse struct {
+		AEAD []byte
+	}
+
+	OTPDecryptResponse struct {
+		UseCounter     uint16
+		SessionCounter uint8
+		// Timestamp is the 24 bit timestamp of the OTP
+		Timestamp []byte
+	}
+
+	PutOTPAeadKeyResponse struct {
+		ObjectID uint16
+	}
+
+	GenerateOTPAeadKeyResponse struct {
+		ObjectID uint16
+	}
+
+	SignSSHCertificateResponse struct {
+		Certificate []byte
+	}
+
+	GetOptionResponse struct {
+		Data []byte
+	}
+
+	StorageStatusResponse struct {
+		TotalRecords uint16
+		FreeRecords  uint16
+		TotalPages   uint16
+		FreePages    uint16
+		PageSize     uint16
+	}
+
+	// LogEntry is an entry of the audit log of the HSM
+	LogEntry struct {
+		Index      uint16
+		Command    CommandType
+		Length     uint16
+		SessionKey uint16
+		TargetKey  uint16
+		SecondKey  uint16
+		Result     uint8
+		Systick    uint32
+		Digest     [16]byte
+	}
+
+	GetLogsResponse struct {
+		UnloggedBoot uint16
+		UnloggedAuth uint16
+		Entries      []LogEntry
+	}
+
+	VerifyHMACResponse struct {
+		Verified bool
+	}
+
+	HMACDataResponse struct {
+		HMAC []byte
+	}
+)
+
+const (
+	// LengthExact means that the payload must be exactly Expected bytes long
+	LengthExact LengthConstraint = iota
+	// LengthAtLeast means that the payload must be at least Expected bytes long
+	LengthAtLeast
+	// LengthMultipleOf means that the payload length must be a multiple of Expected
+	LengthMultipleOf
+)
+
+// Errors returned by the HSM; compare them using errors.Is
+var (
+	ErrInvalidCommand           = &Error{Code: ErrorCodeInvalidCommand}
+	ErrInvalidData              = &Error{Code: ErrorCodeInvalidData}
+	ErrInvalidSession           = &Error{Code: ErrorCodeInvalidSession}
+	ErrAuthFail                 = &Error{Code: ErrorCodeAuthFail}
+	ErrSessionFull              = &Error{Code: ErrorCodeSessionFull}
+	ErrSessionFailed            = &Error{Code: ErrorCodeSessionFailed}
+	ErrStorageFailed            = &Error{Code: ErrorCodeStorageFailed}
+	ErrWrongLength              = &Error{Code: ErrorCodeWrongLength}
+	ErrInvalidPermission        = &Error{Code: ErrorCodeInvalidPermission}
+	ErrLogFull                  = &Error{Code: ErrorCodeLogFull}
+	ErrObjectNotFound           = &Error{Code: ErrorCodeObjectNotFound}
+	ErrInvalidID                = &Error{Code: ErrorCodeInvalidID}
+	ErrSSHCAConstraintViolation = &Error{Code: ErrorCodeSSHCAConstraintViolation}
+	ErrInvalidOTP               = &Error{Code: ErrorCodeInvalidOTP}
+	ErrDemoMode                 = &Error{Code: ErrorCodeDemoMode}
+	ErrObjectExists             = &Error{Code: ErrorCodeObjectExists}
+	ErrAlgorithmDisabled        = &Error{Code: ErrorCodeAlgorithmDisabled}
+	ErrCommandUnexecuted        = &Error{Code: ErrorCodeCommandUnexecuted}
 )
 
 // ParseResponse parses the binary response from the card to the relevant Response type.
@@ -157,6 +308,10 @@ func ParseResponse(data []byte) (Response, error) {
 		return parseCreateSessionResponse(payload)
 	case CommandTypeAuthenticateSession:
 		return nil, nil
+	case CommandTypeReset:
+		return nil, nil
+	case CommandTypeGetDevicePublicKey:
+		return parseGetDevicePublicKeyResponse(payload)
 	case CommandTypeSessionMessage:
 		return parseSessionMessage(payload)
 	case CommandTypeGenerateAsymmetricKey:
@@ -167,6 +322,10 @@ func ParseResponse(data []byte) (Response, error) {
 		return parseSignDataEcdsaResponse(payload)
 	case CommandTypeSignDataPkcs1:
 		return parseSignDataPkcs1Response(payload)
+	case CommandTypeSignDataPss:
+		return parseSignDataPssResponse(payload)
+	case CommandTypeDecryptOaep:
+		return parseDecryptOaepResponse(payload)
 	case CommandTypePutAsymmetric:
 		return parsePutAsymmetricKeyResponse(payload)
 	case CommandTypeListObjects:
@@ -186,7 +345,7 @@ func ParseResponse(data []byte) (Response, error) {
 	case CommandTypeChangeAuthenticationKey:
 		return parseChangeAuthenticationKeyResponse(payload)
 	case CommandTypeGetPseudoRandom:
-		return parseGetPseudoRandomResponse(payload), nil
+		return parseGetPseudoRandomResponse(payload)
 	case CommandTypePutWrapKey:
 		return parsePutWrapkeyResponse(payload)
 	case CommandTypePutAuthKey:
@@ -201,6 +360,36 @@ func ParseResponse(data []byte) (Response, error) {
 		return parseExportWrappedResponse(payload)
 	case CommandTypeImportWrapped:
 		return parseImportWrappedResponse(payload)
+	case CommandTypeWrapData:
+		return parseWrapDataResponse(payload)
+	case CommandTypeUnwrapData:
+		return parseUnwrapDataResponse(payload)
+	case CommandTypeHMACData:
+		return parseHMACDataResponse(payload)
+	case CommandTypeVerifyHMAC:
+		return parseVerifyHMACResponse(payload)
+	case CommandTypeGetLogs:
+		return parseGetLogsResponse(payload)
+	case CommandTypeStorageStatus:
+		return parseStorageStatusResponse(payload)
+	case CommandTypeGetOption:
+		return parseGetOptionResponse(payload)
+	case CommandTypeSetBlink:
+		return nil, nil
+	case CommandTypeSshCertify:
+		return parseSignSSHCertificateResponse(payload)
+	case CommandTypePutOTPAeadKey:
+		return parsePutOTPAeadKeyResponse(payload)
+	case CommandTypeGenerateOTPAeadKey:
+		return parseGenerateOTPAeadKeyResponse(payload)
+	case CommandTypeOTPDecrypt:
+		return parseOTPDecryptResponse(payload)
+	case CommandTypeOTPAeadCreate:
+		return parseOTPAeadCreateResponse(payload)
+	case CommandTypeOTPAeadRandom:
+		return parseOTPAeadRandomResponse(payload)
+	case CommandTypeOTPAeadRewrap:
+		return parseOTPAeadRewrapResponse(payload)
 	case ErrorResponseCode:
 		return nil, parseErrorResponse(payload)
 	default:
@@ -210,7 +399,7 @@ func ParseResponse(data []byte) (Response, error) {
 
 func parseErrorResponse(payload []byte) error {
 	if len(payload) != 1 {
-		return errors.New("invalid response payload length")
+		return newPayloadLengthError(ErrorResponseCode, LengthExact, 1, len(payload))
 	}
 
 	return &Error{
@@ -219,6 +408,10 @@ func parseErrorResponse(payload []byte) error {
 }
 
 func parseSessionMessage(payload []byte) (Response, error) {
+	if len(payload) < 9 {
+		return nil, newPayloadLengthError(CommandTypeSessionMessage, LengthAtLeast, 9, len(payload))
+	}
+
 	return &SessionMessageResponse{
 		SessionID:     payload[0],
 		EncryptedData: payload[1 : len(payload)-8],
@@ -227,6 +420,10 @@ func parseSessionMessage(payload []byte) (Response, error) {
 }
 
 func parseDeviceInfoResponse(payload []byte) (Response, error) {
+	if len(payload) < 9 {
+		return nil, newPayloadLengthError(CommandTypeDeviceInfo, LengthAtLeast, 9, len(payload))
+	}
+
 	var serialNumber uint32
 	err := binary.Read(bytes.NewReader(payload[3:7]), binary.BigEndian, &serialNumber)
 	if err != nil {
@@ -249,8 +446,16 @@ func parseDeviceInfoResponse(payload []byte) (Response, error) {
 	}, nil
 }
 func parseCreateSessionResponse(payload []byte) (Response, error) {
+	if len(payload) == 82 {
+		return &CreateSessionAsymmetricResponse{
+			SessionID:     uint8(payload[0]),
+			CardPublicKey: payload[1:66],
+			Receipt:       payload[66:],
+		}, nil
+	}
+
 	if len(payload) != 17 {
-		return nil, errors.New("invalid response payload length")
+		return nil, newPayloadLengthError(CommandTypeCreateSession, LengthExact, 17, len(payload))
 	}
 
 	return &CreateSessionResponse{
@@ -262,11 +467,11 @@ func parseCreateSessionResponse(payload []byte) (Response, error) {
 
 func parseCreateAsymmetricKeyResponse(payload []byte) (Response, error) {
 	if len(payload) != 2 {
-		return nil, errors.New("invalid response payload length")
+		return nil, newPayloadLengthError(CommandTypeGenerateAsymmetricKey, LengthExact, 2, len(payload))
 	}
 
 	var keyID uint16
-	err := binary.Read(bytes.NewReader(payload[1:3]), binary.BigEndian, &keyID)
+	err := binary.Read(bytes.NewReader(payload), binary.BigEndian, &keyID)
 	if err != nil {
 		return nil, err
 	}
@@ -277,6 +482,10 @@ func parseCreateAsymmetricKeyResponse(payload []byte) (Response, error) {
 }
 
 func parseSignDataEddsaResponse(payload []byte) (Response, error) {
+	if len(payload) != ed25519.SignatureSize {
+		return nil, newPayloadLengthError(CommandTypeSignDataEddsa, LengthExact, ed25519.SignatureSize, len(payload))
+	}
+
 	return &SignDataEddsaResponse{
 		Signature: payload,
 	}, nil
@@ -284,7 +493,7 @@ func parseSignDataEddsaResponse(payload []byte) (Response, error) {
 
 func parseSignDataPkcs1Response(payload []byte) (Response, error) {
 	if len(payload) < 1 {
-		return nil, errors.New("invalid response payload length")
+		return nil, newPayloadLengthError(CommandTypeSignDataPkcs1, LengthAtLeast, 1, len(payload))
 	}
 
 	return &SignDataPkcs1Response{
@@ -298,9 +507,25 @@ func parseSignDataEcdsaResponse(payload []byte) (Response, error) {
 	}, nil
 }
 
+func parseSignDataPssResponse(payload []byte) (Response, error) {
+	if len(payload) < 1 {
+		return nil, newPayloadLengthError(CommandTypeSignDataPss, LengthAtLeast, 1, len(payload))
+	}
+
+	return &SignDataPssResponse{
+		Signature: payload,
+	}, nil
+}
+
+func parseDecryptOaepResponse(payload []byte) (Response, error) {
+	return &DecryptOaepResponse{
+		Decrypted: payload,
+	}, nil
+}
+
 func parsePutAsymmetricKeyResponse(payload []byte) (Response, error) {
 	if len(payload) != 2 {
-		return nil, errors.New("invalid response payload length")
+		return nil, newPayloadLengthError(CommandTypePutAsymmetric, LengthExact, 2, len(payload))
 	}
 
 	var keyID uint16
@@ -316,7 +541,7 @@ func parsePutAsymmetricKeyResponse(payload []byte) (Response, error) {
 
 func parseListObjectsResponse(payload []byte) (Response, error) {
 	if len(payload)%4 != 0 {
-		return nil, errors.New("invalid response payload length")
+		return nil, newPayloadLengthError(CommandTypeListObjects, LengthMultipleOf, 4, len(payload))
 	}
 
 	response := ListObjectsResponse{
@@ -344,7 +569,7 @@ func parseGetObjectInfoResponse(payload []byte) (Response, error) {
 
 func parseGetPubKeyResponse(payload []byte) (Response, error) {
 	if len(payload) < 1 {
-		return nil, errors.New("invalid response payload length")
+		return nil, newPayloadLengthError(CommandTypeGetPubKey, LengthAtLeast, 1, len(payload))
 	}
 	return &GetPubKeyResponse{
 		Algorithm: Algorithm(payload[0]),
@@ -352,6 +577,17 @@ func parseGetPubKeyResponse(payload []byte) (Response, error) {
 	}, nil
 }
 
+func parseGetDevicePublicKeyResponse(payload []byte) (Response, error) {
+	if len(payload) != 65 {
+		return nil, newPayloadLengthError(CommandTypeGetDevicePublicKey, LengthExact, 65, len(payload))
+	}
+
+	return &GetDevicePublicKeyResponse{
+		Algorithm: Algorithm(payload[0]),
+		KeyData:   payload[1:],
+	}, nil
+}
+
 func parseEchoResponse(payload []byte) (Response, error) {
 	return &EchoResponse{
 		Data: payload,
@@ -366,7 +602,7 @@ func parseDeriveEcdhResponse(payload []byte) (Response, error) {
 
 func parseChangeAuthenticationKeyResponse(payload []byte) (Response, error) {
 	if len(payload) != 2 {
-		return nil, errors.New("invalid response payload length")
+		return nil, newPayloadLengthError(CommandTypeChangeAuthenticationKey, LengthExact, 2, len(payload))
 	}
 
 	var objectID uint16
@@ -378,13 +614,15 @@ func parseChangeAuthenticationKeyResponse(payload []byte) (Response, error) {
 	return &ChangeAuthenticationKeyResponse{ObjectID: objectID}, nil
 }
 
-func parseGetPseudoRandomResponse(payload []byte) Response {
-	return payload
+func parseGetPseudoRandomResponse(payload []byte) (Response, error) {
+	return &GetPseudoRandomResponse{
+		Data: payload,
+	}, nil
 }
 
 func parsePutWrapkeyResponse(payload []byte) (Response, error) {
 	if len(payload) != 2 {
-		return nil, errors.New("invalid response payload length")
+		return nil, newPayloadLengthError(CommandTypePutWrapKey, LengthExact, 2, len(payload))
 	}
 
 	var objectID uint16
@@ -397,7 +635,7 @@ func parsePutWrapkeyResponse(payload []byte) (Response, error) {
 
 func parsePutAuthkeyResponse(payload []byte) (Response, error) {
 	if len(payload) != 2 {
-		return nil, errors.New("invalid response payload length")
+		return nil, newPayloadLengthError(CommandTypePutAuthKey, LengthExact, 2, len(payload))
 	}
 
 	var objectID uint16
@@ -411,7 +649,7 @@ func parsePutAuthkeyResponse(payload []byte) (Response, error) {
 
 func parsePutOpaqueResponse(payload []byte) (Response, error) {
 	if len(payload) != 2 {
-		return nil, errors.New("invalid response payload length")
+		return nil, newPayloadLengthError(CommandTypePutOpaque, LengthExact, 2, len(payload))
 	}
 
 	var objectID uint16
@@ -427,7 +665,7 @@ func parsePutOpaqueResponse(payload []byte) (Response, error) {
 
 func parseGetOpaqueResponse(payload []byte) (Response, error) {
 	if len(payload) < 1 {
-		return nil, errors.New("invalid response payload length")
+		return nil, newPayloadLengthError(CommandTypeGetOpaque, LengthAtLeast, 1, len(payload))
 	}
 
 	return &GetOpaqueResponse{
@@ -437,7 +675,7 @@ func parseGetOpaqueResponse(payload []byte) (Response, error) {
 
 func parseAttestationCertResponse(payload []byte) (Response, error) {
 	if len(payload) < 1 {
-		return nil, errors.New("invalid response payload length")
+		return nil, newPayloadLengthError(CommandTypeAttestAsymmetric, LengthAtLeast, 1, len(payload))
 	}
 
 	return &SignAttestationCertResponse{
@@ -446,19 +684,36 @@ func parseAttestationCertResponse(payload []byte) (Response, error) {
 }
 
 func parseExportWrappedResponse(payload []byte) (Response, error) {
-	if len(payload) < 13 {
-		return nil, errors.New("invalid response payload length")
+	if len(payload) < WrapNonceLength {
+		return nil, newPayloadLengthError(CommandTypeExportWrapped, LengthAtLeast, WrapNonceLength, len(payload))
 	}
 
 	return &ExportWrappedResponse{
-		Nonce: payload[:13],
-		Data:  payload[13:],
+		Nonce: payload[:WrapNonceLength],
+		Data:  payload[WrapNonceLength:],
+	}, nil
+}
+
+func parseWrapDataResponse(payload []byte) (Response, error) {
+	if len(payload) < WrapNonceLength {
+		return nil, newPayloadLengthError(CommandTypeWrapData, LengthAtLeast, WrapNonceLength, len(payload))
+	}
+
+	return &WrapDataResponse{
+		Nonce: payload[:WrapNonceLength],
+		Data:  payload[WrapNonceLength:],
+	}, nil
+}
+
+func parseUnwrapDataResponse(payload []byte) (Response, error) {
+	return &UnwrapDataResponse{
+		Data: payload,
 	}, nil
 }
 
 func parseImportWrappedResponse(payload []byte) (Response, error) {
 	if len(payload) != 3 {
-		return nil, errors.New("invalid response payload length")
+		return nil, newPayloadLengthError(CommandTypeImportWrapped, LengthExact, 3, len(payload))
 	}
 
 	var objID uint16
@@ -473,6 +728,150 @@ func parseImportWrappedResponse(payload []byte) (Response, error) {
 	}, nil
 }
 
+func parseHMACDataResponse(payload []byte) (Response, error) {
+	if len(payload) < 1 {
+		return nil, newPayloadLengthError(CommandTypeHMACData, LengthAtLeast, 1, len(payload))
+	}
+
+	return &HMACDataResponse{
+		HMAC: payload,
+	}, nil
+}
+
+func parseVerifyHMACResponse(payload []byte) (Response, error) {
+	if len(payload) != 1 {
+		return nil, newPayloadLengthError(CommandTypeVerifyHMAC, LengthExact, 1, len(payload))
+	}
+
+	return &VerifyHMACResponse{
+		Verified: payload[0] == 1,
+	}, nil
+}
+
+func parseGetLogsResponse(payload []byte) (Response, error) {
+	if len(payload) < 5 {
+		return nil, newPayloadLengthError(CommandTypeGetLogs, LengthAtLeast, 5, len(payload))
+	}
+
+	response := GetLogsResponse{
+		UnloggedBoot: binary.BigEndian.Uint16(payload[0:2]),
+		UnloggedAuth: binary.BigEndian.Uint16(payload[2:4]),
+		Entries:      make([]LogEntry, payload[4]),
+	}
+
+	entries := payload[5:]
+	if len(entries) != len(response.Entries)*binary.Size(LogEntry{}) {
+		return nil, newPayloadLengthError(CommandTypeGetLogs, LengthExact, 5+len(response.Entries)*binary.Size(LogEntry{}), len(payload))
+	}
+
+	err := binary.Read(bytes.NewReader(entries), binary.BigEndian, &response.Entries)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+func parseStorageStatusResponse(payload []byte) (Response, error) {
+	if len(payload) != 10 {
+		return nil, newPayloadLengthError(CommandTypeStorageStatus, LengthExact, 10, len(payload))
+	}
+
+	response := StorageStatusResponse{}
+	err := binary.Read(bytes.NewReader(payload), binary.BigEndian, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+func parseGetOptionResponse(payload []byte) (Response, error) {
+	return &GetOptionResponse{
+		Data: payload,
+	}, nil
+}
+
+func parseSignSSHCertificateResponse(payload []byte) (Response, error) {
+	if len(payload) < 1 {
+		return nil, newPayloadLengthError(CommandTypeSshCertify, LengthAtLeast, 1, len(payload))
+	}
+
+	return &SignSSHCertificateResponse{
+		Certificate: payload,
+	}, nil
+}
+
+func parsePutOTPAeadKeyResponse(payload []byte) (Response, error) {
+	if len(payload) != 2 {
+		return nil, newPayloadLengthError(CommandTypePutOTPAeadKey, LengthExact, 2, len(payload))
+	}
+
+	var objectID uint16
+	err := binary.Read(bytes.NewReader(payload), binary.BigEndian, &objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PutOTPAeadKeyResponse{ObjectID: objectID}, nil
+}
+
+func parseGenerateOTPAeadKeyResponse(payload []byte) (Response, error) {
+	if len(payload) != 2 {
+		return nil, newPayloadLengthError(CommandTypeGenerateOTPAeadKey, LengthExact, 2, len(payload))
+	}
+
+	var objectID uint16
+	err := binary.Read(bytes.NewReader(payload), binary.BigEndian, &objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GenerateOTPAeadKeyResponse{ObjectID: objectID}, nil
+}
+
+func parseOTPDecryptResponse(payload []byte) (Response, error) {
+	if len(payload) != 6 {
+		return nil, newPayloadLengthError(CommandTypeOTPDecrypt, LengthExact, 6, len(payload))
+	}
+
+	return &OTPDecryptResponse{
+		UseCounter:     binary.BigEndian.Uint16(payload[0:2]),
+		SessionCounter: payload[2],
+		Timestamp:      payload[3:6],
+	}, nil
+}
+
+func parseOTPAeadCreateResponse(payload []byte) (Response, error) {
+	if len(payload) < 1 {
+		return nil, newPayloadLengthError(CommandTypeOTPAeadCreate, LengthAtLeast, 1, len(payload))
+	}
+
+	return &OTPAeadCreateResponse{
+		AEAD: payload,
+	}, nil
+}
+
+func parseOTPAeadRandomResponse(payload []byte) (Response, error) {
+	if len(payload) < 1 {
+		return nil, newPayloadLengthError(CommandTypeOTPAeadRandom, LengthAtLeast, 1, len(payload))
+	}
+
+	return &OTPAeadRandomResponse{
+		AEAD: payload,
+	}, nil
+}
+
+func parseOTPAeadRewrapResponse(payload []byte) (Response, error) {
+	if len(payload) < 1 {
+		return nil, newPayloadLengthError(CommandTypeOTPAeadRewrap, LengthAtLeast, 1, len(payload))
+	}
+
+	return &OTPAeadRewrapResponse{
+		AEAD: payload,
+	}, nil
+}
+
 // Error formats a card error message into a human readable format
 func (e *Error) Error() string {
 	message := ""
@@ -513,9 +912,159 @@ func (e *Error) Error() string {
 		message = "Demo mode"
 	case ErrorCodeObjectExists:
 		message = "Object exists"
+	case ErrorCodeAlgorithmDisabled:
+		message = "Algorithm disabled"
 	default:
 		message = "Unknown"
 	}
 
 	return fmt.Sprintf("card responded with error: %s", message)
 }
+
+// Error formats the PayloadLengthError into a human readable format, e.g. "CreateSession: expected 17 bytes, got 12"
+func (e *PayloadLengthError) Error() string {
+	switch e.Constraint {
+	case LengthAtLeast:
+		return fmt.Sprintf("%s: expected at least %d bytes, got %d", e.CommandType, e.Expected, e.Actual)
+	case LengthMultipleOf:
+		return fmt.Sprintf("%s: expected a multiple of %d bytes, got %d", e.CommandType, e.Expected, e.Actual)
+	default:
+		return fmt.Sprintf("%s: expected %d bytes, got %d", e.CommandType, e.Expected, e.Actual)
+	}
+}
+
+func newPayloadLengthError(commandType CommandType, constraint LengthConstraint, expected, actual int) error {
+	return &PayloadLengthError{
+		CommandType: commandType,
+		Constraint:  constraint,
+		Expected:    expected,
+		Actual:      actual,
+	}
+}
+
+// Is reports whether target is an *Error with the same code. It allows checking errors returned by the HSM with
+// errors.Is, e.g. errors.Is(err, commands.ErrObjectNotFound).
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+
+	return e.Code == t.Code
+}
+
+// Certificate parses the DER encoded attestation certificate
+func (r *SignAttestationCertResponse) Certificate() (*x509.Certificate, error) {
+	return x509.ParseCertificate(r.Cert)
+}
+
+// RS parses the DER encoded signature and returns its r and s values
+func (r *SignDataEcdsaResponse) RS() (*big.Int, *big.Int, error) {
+	var signature struct {
+		R, S *big.Int
+	}
+
+	rest, err := asn1.Unmarshal(r.Signature, &signature)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rest) != 0 {
+		return nil, nil, errors.New("trailing data after ECDSA signature")
+	}
+
+	return signature.R, signature.S, nil
+}
+
+// LabelString returns the label of the object without the trailing zero padding.
+// The Label field is kept as an array so that the response can be decoded using binary.Read.
+func (r *ObjectInfoResponse) LabelString() string {
+	return string(bytes.TrimRight(r.Label[:], "\x00"))
+}
+
+// keyBits maps the key algorithms to their key size in bits where it is not the stored length of the object
+var keyBits = map[Algorithm]int{
+	AlgorithmRSA2048:            2048,
+	AlgorithmRSA3072:            3072,
+	AlgorithmRSA4096:            4096,
+	AlgorithmP224:               224,
+	AlgorithmP256:               256,
+	AlgorithmP384:               384,
+	AlgorithmP521:               521,
+	AlgorithmSecp256k1:          256,
+	AlgorithmECBP256:            256,
+	AlgorithmECBP384:            384,
+	AlgorithmECBP512:            512,
+	AlgorithmED25519:            256,
+	AlgorithmP256Authentication: 256,
+}
+
+// KeyBits returns the size of the key in bits, e.g. the modulus size of RSA keys or the curve size of EC keys. For
+// other objects like HMAC or wrap keys it is derived from the stored length.
+func (r *ObjectInfoResponse) KeyBits() int {
+	if bits, ok := keyBits[r.Algorithm]; ok {
+		return bits
+	}
+
+	return int(r.Length) * 8
+}
+
+// ecCoordinateLengths maps the EC algorithms to the length of a coordinate on their curve
+var ecCoordinateLengths = map[Algorithm]int{
+	AlgorithmP224:      28,
+	AlgorithmP256:      32,
+	AlgorithmP384:      48,
+	AlgorithmP521:      66,
+	AlgorithmSecp256k1: 32,
+	AlgorithmECBP256:   32,
+	AlgorithmECBP384:   48,
+	AlgorithmECBP512:   64,
+}
+
+// SharedSecret returns the X coordinate left-padded with zeros to the coordinate length of the curve. algorithm is
+// the algorithm of the key used for the derivation. Use it instead of XCoordinate when the secret is fed into a KDF,
+// since the device may strip leading zero bytes.
+func (r *DeriveEcdhResponse) SharedSecret(algorithm Algorithm) ([]byte, error) {
+	length, ok := ecCoordinateLengths[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("algorithm %s is not an ec key algorithm", algorithm)
+	}
+	if len(r.XCoordinate) > length {
+		return nil, fmt.Errorf("x coordinate is %d bytes long and exceeds the %d bytes of algorithm %s", len(r.XCoordinate), length, algorithm)
+	}
+
+	secret := make([]byte, length)
+	copy(secret[length-len(r.XCoordinate):], r.XCoordinate)
+
+	return secret, nil
+}
+
+// CommandAudit decodes the value of the OptionCommandAudit option, which consists of pairs of a command and its
+// audit setting
+func (r *GetOptionResponse) CommandAudit() (map[CommandType]AuditSetting, error) {
+	if len(r.Data)%2 != 0 {
+		return nil, newPayloadLengthError(CommandTypeGetOption, LengthMultipleOf, 2, len(r.Data))
+	}
+
+	settings := make(map[CommandType]AuditSetting, len(r.Data)/2)
+	for i := 0; i < len(r.Data); i += 2 {
+		settings[CommandType(r.Data[i])] = AuditSetting(r.Data[i+1])
+	}
+
+	return settings, nil
+}
+
+// IsGenerated returns whether the object was generated on the HSM
+func (r *ObjectInfoResponse) IsGenerated() bool {
+	return r.Origin&ObjectOriginGenerated != 0
+}
+
+// IsImported returns whether the object was imported into the HSM in plaintext
+func (r *ObjectInfoResponse) IsImported() bool {
+	return r.Origin&ObjectOriginImported != 0
+}
+
+// WasUnderWrap returns whether the object was imported under wrap, e.g. restored from a backup. The generated or
+// imported flag is kept from the original object.
+func (r *ObjectInfoResponse) WasUnderWrap() bool {
+	return r.Origin&ObjectOriginImportedWrapped != 0
+}
@@ -0,0 +1,142 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// SerializeResponse returns the wire format of a response as it is sent by the HSM. It is the inverse of
+// ParseResponse and can be used to build fake devices or to record and replay traffic.
+// Responses without payload (e.g. to CloseSession) are represented as nil by ParseResponse and are not supported.
+func SerializeResponse(r Response) ([]byte, error) {
+	payload := new(bytes.Buffer)
+	var commandType CommandType
+
+	switch resp := r.(type) {
+	case *Error:
+		return []byte{byte(ErrorResponseCode - ResponseCommandOffset), 0x00, 0x01, byte(resp.Code)}, nil
+	case *CreateSessionResponse:
+		commandType = CommandTypeCreateSession
+		payload.WriteByte(resp.SessionID)
+		payload.Write(resp.CardChallenge)
+		payload.Write(resp.CardCryptogram)
+	case *CreateSessionAsymmetricResponse:
+		commandType = CommandTypeCreateSession
+		payload.WriteByte(resp.SessionID)
+		payload.Write(resp.CardPublicKey)
+		payload.Write(resp.Receipt)
+	case *SessionMessageResponse:
+		commandType = CommandTypeSessionMessage
+		payload.WriteByte(resp.SessionID)
+		payload.Write(resp.EncryptedData)
+		payload.Write(resp.MAC)
+	case *DeviceInfoResponse:
+		commandType = CommandTypeDeviceInfo
+		payload.Write([]byte{resp.MajorVersion, resp.MinorVersion, resp.BuildVersion})
+		binary.Write(payload, binary.BigEndian, resp.SerialNumber)
+		payload.Write([]byte{resp.LogTotal, resp.LogUsed})
+		for _, algorithm := range resp.SupportedAlgorithms {
+			payload.WriteByte(byte(algorithm))
+		}
+	case *CreateAsymmetricKeyResponse:
+		commandType = CommandTypeGenerateAsymmetricKey
+		binary.Write(payload, binary.BigEndian, resp.KeyID)
+	case *PutAsymmetricKeyResponse:
+		commandType = CommandTypePutAsymmetric
+		binary.Write(payload, binary.BigEndian, resp.KeyID)
+	case *SignDataEddsaResponse:
+		commandType = CommandTypeSignDataEddsa
+		payload.Write(resp.Signature)
+	case *SignDataEcdsaResponse:
+		commandType = CommandTypeSignDataEcdsa
+		payload.Write(resp.Signature)
+	case *SignDataPkcs1Response:
+		commandType = CommandTypeSignDataPkcs1
+		payload.Write(resp.Signature)
+	case *SignDataPssResponse:
+		commandType = CommandTypeSignDataPss
+		payload.Write(resp.Signature)
+	case *DecryptOaepResponse:
+		commandType = CommandTypeDecryptOaep
+		payload.Write(resp.Decrypted)
+	case *ListObjectsResponse:
+		commandType = CommandTypeListObjects
+		binary.Write(payload, binary.BigEndian, resp.Objects)
+	case *ObjectInfoResponse:
+		commandType = CommandTypeGetObjectInfo
+		binary.Write(payload, binary.BigEndian, resp)
+	case *GetPubKeyResponse:
+		commandType = CommandTypeGetPubKey
+		payload.WriteByte(byte(resp.Algorithm))
+		payload.Write(resp.KeyData)
+	case *EchoResponse:
+		commandType = CommandTypeEcho
+		payload.Write(resp.Data)
+	case *DeriveEcdhResponse:
+		commandType = CommandTypeDeriveEcdh
+		payload.Write(resp.XCoordinate)
+	case *GetPseudoRandomResponse:
+		commandType = CommandTypeGetPseudoRandom
+		payload.Write(resp.Data)
+	case *ChangeAuthenticationKeyResponse:
+		commandType = CommandTypeChangeAuthenticationKey
+		binary.Write(payload, binary.BigEndian, resp.ObjectID)
+	case *PutWrapkeyResponse:
+		commandType = CommandTypePutWrapKey
+		binary.Write(payload, binary.BigEndian, resp.ObjectID)
+	case *PutAuthkeyResponse:
+		commandType = CommandTypePutAuthKey
+		binary.Write(payload, binary.BigEndian, resp.ObjectID)
+	case *PutOpaqueResponse:
+		commandType = CommandTypePutOpaque
+		binary.Write(payload, binary.BigEndian, resp.ObjectID)
+	case *GetOpaqueResponse:
+		commandType = CommandTypeGetOpaque
+		payload.Write(resp.Data)
+	case *SignAttestationCertResponse:
+		commandType = CommandTypeAttestAsymmetric
+		payload.Write(resp.Cert)
+	case *ExportWrappedResponse:
+		commandType = CommandTypeExportWrapped
+		payload.Write(resp.Nonce)
+		payload.Write(resp.Data)
+	case *ImportWrappedResponse:
+		commandType = CommandTypeImportWrapped
+		payload.WriteByte(resp.ObjectType)
+		binary.Write(payload, binary.BigEndian, resp.ObjectID)
+	case *WrapDataResponse:
+		commandType = CommandTypeWrapData
+		payload.Write(resp.Nonce)
+		payload.Write(resp.Data)
+	case *UnwrapDataResponse:
+		commandType = CommandTypeUnwrapData
+		payload.Write(resp.Data)
+	case *GetOptionResponse:
+		commandType = CommandTypeGetOption
+		payload.Write(resp.Data)
+	case *HMACDataResponse:
+		commandType = CommandTypeHMACData
+		payload.Write(resp.HMAC)
+	case *VerifyHMACResponse:
+		commandType = CommandTypeVerifyHMAC
+		if resp.Verified {
+			payload.WriteByte(1)
+		} else {
+			payload.WriteByte(0)
+		}
+	default:
+		return nil, fmt.Errorf("serializing responses of type %T is not supported", r)
+	}
+
+	if length := 3 + payload.Len(); length > MaxMessageSize {
+		return nil, fmt.Errorf("response is %d bytes long and exceeds the maximum message size of %d bytes", length, MaxMessageSize)
+	}
+
+	buffer := new(bytes.Buffer)
+	buffer.WriteByte(byte(commandType) | ResponseCommandOffset)
+	binary.Write(buffer, binary.BigEndian, uint16(payload.Len()))
+	buffer.Write(payload.Bytes())
+
+	return buffer.Bytes(), nil
+}
@@ -1,5 +1,10 @@
 package commands
 
+import (
+	"fmt"
+	"strings"
+)
+
 type (
 	CommandType uint8
 	ErrorCode   uint8
@@ -8,17 +13,31 @@ type (
 
 const (
 	ResponseCommandOffset = 0x80
-	ErrorResponseCode     = 0xff
+	// ErrorResponseCode is the command byte of an error response (0x7f) after adding the ResponseCommandOffset in
+	// ParseResponse. It is matched against the command byte only; the actual error is the ErrorCode in the payload.
+	ErrorResponseCode CommandType = 0xff
 
 	// LabelLength is the max length of a label
 	LabelLength = 40
 
+	// MaxMessageSize is the size of the HSM's message buffer and limits the length of a serialized command including
+	// its header. The protocol does not support splitting commands across messages.
+	MaxMessageSize = 2048
+
+	// WrapNonceLength is the length of the nonce used by the AES-CCM wrap operations
+	WrapNonceLength = 13
+
+	// DeviceAttestationObjectID is the ID of the factory installed attestation key and of the opaque object holding
+	// its certificate, which is issued by Yubico
+	DeviceAttestationObjectID uint16 = 0
+
 	CommandTypeEcho                    CommandType = 0x01
 	CommandTypeCreateSession           CommandType = 0x03
 	CommandTypeAuthenticateSession     CommandType = 0x04
 	CommandTypeSessionMessage          CommandType = 0x05
 	CommandTypeDeviceInfo              CommandType = 0x06
 	CommandTypeReset                   CommandType = 0x08
+	CommandTypeGetDevicePublicKey      CommandType = 0x0a
 	CommandTypeCloseSession            CommandType = 0x40
 	CommandTypeStorageStatus           CommandType = 0x41
 	CommandTypePutOpaque               CommandType = 0x42
@@ -49,6 +68,7 @@ const (
 	CommandTypeGenerateHMACKey         CommandType = 0x5a
 	CommandTypeGenerateWrapKey         CommandType = 0x5b
 	CommandTypeVerifyHMAC              CommandType = 0x5c
+	CommandTypeSshCertify              CommandType = 0x5d
 	CommandTypeOTPDecrypt              CommandType = 0x60
 	CommandTypeOTPAeadCreate           CommandType = 0x61
 	CommandTypeOTPAeadRandom           CommandType = 0x62
@@ -81,7 +101,8 @@ const (
 	ErrorCodeInvalidOTP               ErrorCode = 0x0f
 	ErrorCodeDemoMode                 ErrorCode = 0x10
 	ErrorCodeObjectExists             ErrorCode = 0x11
-	ErrorCodeCommandUnexecuted        ErrorCode = 0xff
+	ErrorCodeAlgorithmDisabled        ErrorCode = 0x12
+	ErrorCodeCommandUnexecuted        ErrorCode = 0xff // sent in the payload of an ErrorResponseCode response
 
 	// Algorithms
 	AlgorithmRSAPKCS1SHA1            Algorithm = 1
@@ -130,7 +151,9 @@ const (
 	AlgorithmECECDSASHA384           Algorithm = 44
 	AlgorithmECECDSASHA512           Algorithm = 45
 	AlgorithmED25519                 Algorithm = 46
-	AlgorithmECP224                  Algorithm = 47
+	AlgorithmECP224                  Algorithm = 47 // here for backwards compatibility
+	AlgorithmP224                    Algorithm = 47
+	AlgorithmP256Authentication      Algorithm = 49 // used by asymmetric authentication keys
 
 	// Capabilities
 	CapabilityNone                    uint64 = 0x0000000000000000
@@ -210,6 +233,11 @@ const (
 	ObjectTypeTemplate          uint8 = 0x06
 	ObjectTypeOtpAeadKey        uint8 = 0x07
 
+	// object origins
+	ObjectOriginGenerated       uint8 = 0x01
+	ObjectOriginImported        uint8 = 0x02
+	ObjectOriginImportedWrapped uint8 = 0x10
+
 	// list objects params
 	ListObjectParamID           uint8 = 0x01
 	ListObjectParamType         uint8 = 0x02
@@ -217,6 +245,10 @@ const (
 	ListObjectParamCapabilities uint8 = 0x04
 	ListObjectParamAlgorithm    uint8 = 0x05
 	ListObjectParamLabel        uint8 = 0x06
+
+	// device options
+	OptionForceAudit   uint8 = 0x01
+	OptionCommandAudit uint8 = 0x03
 )
 
 // CapabilityPrimitiveFromSlice OR's all the capabilitites together.
@@ -227,3 +259,208 @@ func CapabilityPrimitiveFromSlice(capabilitites []uint64) uint64 {
 	}
 	return primitive
 }
+
+// algorithmNames maps algorithms to the names used by yubihsm-shell
+var algorithmNames = map[Algorithm]string{
+	AlgorithmRSAPKCS1SHA1:            "rsa-pkcs1-sha1",
+	AlgorithmRSAPKCS1SHA256:          "rsa-pkcs1-sha256",
+	AlgorithmRSAPKCS1SHA384:          "rsa-pkcs1-sha384",
+	AlgorithmRSAPKCS1SHA512:          "rsa-pkcs1-sha512",
+	AlgorithmRSAPSSSHA1:              "rsa-pss-sha1",
+	AlgorithmRSAPSSSHA256:            "rsa-pss-sha256",
+	AlgorithmRSAPSSSHA384:            "rsa-pss-sha384",
+	AlgorithmRSAPSSSHA512:            "rsa-pss-sha512",
+	AlgorithmRSA2048:                 "rsa2048",
+	AlgorithmRSA3072:                 "rsa3072",
+	AlgorithmRSA4096:                 "rsa4096",
+	AlgorithmP256:                    "ecp256",
+	AlgorithmP384:                    "ecp384",
+	AlgorithmP521:                    "ecp521",
+	AlgorithmSecp256k1:               "eck256",
+	AlgorithmECBP256:                 "ecbp256",
+	AlgorithmECBP384:                 "ecbp384",
+	AlgorithmECBP512:                 "ecbp512",
+	AlgorithmHMACSHA1:                "hmac-sha1",
+	AlgorithmHMACSHA256:              "hmac-sha256",
+	AlgorithmHMACSHA384:              "hmac-sha384",
+	AlgorithmHMACSHA512:              "hmac-sha512",
+	AlgorithmECECDSASHA1:             "ecdsa-sha1",
+	AlgorithmECECDH:                  "ecdh",
+	AlgorithmRSAOAEPSHA1:             "rsa-oaep-sha1",
+	AlgorithmRSAOAEPSHA256:           "rsa-oaep-sha256",
+	AlgorithmRSAOAEPSHA384:           "rsa-oaep-sha384",
+	AlgorithmRSAOAEPSHA512:           "rsa-oaep-sha512",
+	AlgorithmAES128CCMWrap:           "aes128-ccm-wrap",
+	AlgorithmOpaqueData:              "opaque-data",
+	AlgorithmOpaqueX509Certificate:   "opaque-x509-certificate",
+	AlgorithmRSAMGF1SHA1:             "mgf1-sha1",
+	AlgorithmRSAMGF1SHA256:           "mgf1-sha256",
+	AlgorithmRSAMGF1SHA384:           "mgf1-sha384",
+	AlgorithmRSAMGF1SHA512:           "mgf1-sha512",
+	AlgorithmTEMPLATESSH:             "template-ssh",
+	AlgorithmAES128YUBICOOTP:         "aes128-yubico-otp",
+	AlgorithmYubicoAESAuthentication: "aes128-yubico-authentication",
+	AlgorithmAES192YUBICOOTP:         "aes192-yubico-otp",
+	AlgorithmAES256YUBICOOTP:         "aes256-yubico-otp",
+	AlgorithmAES192CCMWrap:           "aes192-ccm-wrap",
+	AlgorithmAES256CCMWrap:           "aes256-ccm-wrap",
+	AlgorithmECECDSASHA256:           "ecdsa-sha256",
+	AlgorithmECECDSASHA384:           "ecdsa-sha384",
+	AlgorithmECECDSASHA512:           "ecdsa-sha512",
+	AlgorithmED25519:                 "ed25519",
+	AlgorithmP224:                    "ecp224",
+	AlgorithmP256Authentication:      "ecp256-yubico-authentication",
+}
+
+// String returns the canonical name of the algorithm as used by yubihsm-shell
+func (a Algorithm) String() string {
+	if name, ok := algorithmNames[a]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("algorithm(0x%02x)", uint8(a))
+}
+
+// commandTypeNames maps command types to their names
+var commandTypeNames = map[CommandType]string{
+	CommandTypeEcho:                    "Echo",
+	CommandTypeCreateSession:           "CreateSession",
+	CommandTypeAuthenticateSession:     "AuthenticateSession",
+	CommandTypeSessionMessage:          "SessionMessage",
+	CommandTypeDeviceInfo:              "DeviceInfo",
+	CommandTypeReset:                   "Reset",
+	CommandTypeGetDevicePublicKey:      "GetDevicePublicKey",
+	CommandTypeCloseSession:            "CloseSession",
+	CommandTypeStorageStatus:           "StorageStatus",
+	CommandTypePutOpaque:               "PutOpaque",
+	CommandTypeGetOpaque:               "GetOpaque",
+	CommandTypePutAuthKey:              "PutAuthKey",
+	CommandTypePutAsymmetric:           "PutAsymmetric",
+	CommandTypeGenerateAsymmetricKey:   "GenerateAsymmetricKey",
+	CommandTypeSignDataPkcs1:           "SignDataPkcs1",
+	CommandTypeListObjects:             "ListObjects",
+	CommandTypeDecryptPkcs1:            "DecryptPkcs1",
+	CommandTypeExportWrapped:           "ExportWrapped",
+	CommandTypeImportWrapped:           "ImportWrapped",
+	CommandTypePutWrapKey:              "PutWrapKey",
+	CommandTypeGetLogs:                 "GetLogs",
+	CommandTypeGetObjectInfo:           "GetObjectInfo",
+	CommandTypePutOption:               "PutOption",
+	CommandTypeGetOption:               "GetOption",
+	CommandTypeGetPseudoRandom:         "GetPseudoRandom",
+	CommandTypePutHMACKey:              "PutHMACKey",
+	CommandTypeHMACData:                "HMACData",
+	CommandTypeGetPubKey:               "GetPubKey",
+	CommandTypeSignDataPss:             "SignDataPss",
+	CommandTypeSignDataEcdsa:           "SignDataEcdsa",
+	CommandTypeDeriveEcdh:              "DeriveEcdh",
+	CommandTypeDeleteObject:            "DeleteObject",
+	CommandTypeDecryptOaep:             "DecryptOaep",
+	CommandTypeGenerateHMACKey:         "GenerateHMACKey",
+	CommandTypeGenerateWrapKey:         "GenerateWrapKey",
+	CommandTypeVerifyHMAC:              "VerifyHMAC",
+	CommandTypeSshCertify:              "SshCertify",
+	CommandTypeOTPDecrypt:              "OTPDecrypt",
+	CommandTypeOTPAeadCreate:           "OTPAeadCreate",
+	CommandTypeOTPAeadRandom:           "OTPAeadRandom",
+	CommandTypeOTPAeadRewrap:           "OTPAeadRewrap",
+	CommandTypeAttestAsymmetric:        "AttestAsymmetric",
+	CommandTypePutOTPAeadKey:           "PutOTPAeadKey",
+	CommandTypeGenerateOTPAeadKey:      "GenerateOTPAeadKey",
+	CommandTypeSetLogIndex:             "SetLogIndex",
+	CommandTypeWrapData:                "WrapData",
+	CommandTypeUnwrapData:              "UnwrapData",
+	CommandTypeSignDataEddsa:           "SignDataEddsa",
+	CommandTypeSetBlink:                "SetBlink",
+	CommandTypeChangeAuthenticationKey: "ChangeAuthenticationKey",
+	ErrorResponseCode:                  "Error",
+}
+
+// String returns the name of the command type
+func (t CommandType) String() string {
+	if name, ok := commandTypeNames[t]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("command(0x%02x)", uint8(t))
+}
+
+// ObjectType is the type of an object as used by the ObjectType constants and ObjectInfoResponse.Type
+type ObjectType uint8
+
+// objectTypeNames maps object types to the names used by yubihsm-shell
+var objectTypeNames = map[uint8]string{
+	ObjectTypeOpaque:            "opaque",
+	ObjectTypeAuthenticationKey: "authentication-key",
+	ObjectTypeAsymmetricKey:     "asymmetric-key",
+	ObjectTypeWrapKey:           "wrap-key",
+	ObjectTypeHmacKey:           "hmac-key",
+	ObjectTypeTemplate:          "template",
+	ObjectTypeOtpAeadKey:        "otp-aead-key",
+}
+
+// String returns the name of the object type as used by yubihsm-shell
+func (t ObjectType) String() string {
+	if name, ok := objectTypeNames[uint8(t)]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("type(0x%02x)", uint8(t))
+}
+
+// ObjectOrigin is a set of the ObjectOrigin flags as used by ObjectInfoResponse.Origin
+type ObjectOrigin uint8
+
+// objectOriginNames maps the origin flags to their names in the order of their bits
+var objectOriginNames = []struct {
+	origin uint8
+	name   string
+}{
+	{ObjectOriginGenerated, "generated"},
+	{ObjectOriginImported, "imported"},
+	{ObjectOriginImportedWrapped, "imported_wrapped"},
+}
+
+// String returns the names of the origin flags separated by colons, e.g. "generated:imported_wrapped" for a generated
+// key that was restored from a backup. Unknown bits are formatted as hex values.
+func (o ObjectOrigin) String() string {
+	var names []string
+	remaining := uint8(o)
+	for _, origin := range objectOriginNames {
+		if remaining&origin.origin != 0 {
+			names = append(names, origin.name)
+			remaining &^= origin.origin
+		}
+	}
+	if remaining != 0 {
+		names = append(names, fmt.Sprintf("0x%02x", remaining))
+	}
+
+	return strings.Join(names, ":")
+}
+
+// AuditSetting is the audit state of a device option or of a command in the command audit option
+type AuditSetting uint8
+
+const (
+	// AuditOff disables auditing
+	AuditOff AuditSetting = 0x00
+	// AuditOn enables auditing
+	AuditOn AuditSetting = 0x01
+	// AuditFix enables auditing permanently; it can only be disabled by a reset
+	AuditFix AuditSetting = 0x02
+)
+
+// String returns the name of the audit setting as used by yubihsm-shell
+func (a AuditSetting) String() string {
+	switch a {
+	case AuditOff:
+		return "off"
+	case AuditOn:
+		return "on"
+	case AuditFix:
+		return "fix"
+	default:
+		return fmt.Sprintf("audit(0x%02x)", uint8(a))
+	}
+}
@@ -1,12 +1,19 @@
 package connector
 
-import "github.com/certusone/yubihsm-go/commands"
+import (
+	"context"
+
+	"github.com/certusone/yubihsm-go/commands"
+)
 
 type (
 	// Connector implements a simple request interface with a YubiHSM2
 	Connector interface {
 		// Request executes a command on the HSM and returns the binary response
 		Request(command *commands.CommandMessage) ([]byte, error)
+		// RequestContext executes a command on the HSM and returns the binary response.
+		// The request is aborted once ctx is done.
+		RequestContext(ctx context.Context, command *commands.CommandMessage) ([]byte, error)
 		// GetStatus requests the status of the HSM connector (not working for direct USB)
 		GetStatus() (*StatusResponse, error)
 	}
@@ -24,3 +31,10 @@ type (
 		Port    string
 	}
 )
+
+const (
+	// StatusOK is reported by the connector if the HSM is present and ready
+	StatusOK Status = "OK"
+	// StatusNoDevice is reported by the connector if no HSM is attached
+	StatusNoDevice Status = "NO_DEVICE"
+)
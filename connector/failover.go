@@ -0,0 +1,100 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/certusone/yubihsm-go/commands"
+)
+
+type (
+	// ServedFunc is called by the FailoverConnector after a backend executed a request. backend is the index of the
+	// backend in the order passed to NewFailoverConnector.
+	ServedFunc func(backend int, command *commands.CommandMessage, err error)
+
+	// FailoverConnector sends requests to one of several backend connectors, e.g. yubihsm-connector instances in
+	// front of different HSMs. All requests go to the active backend; if it fails with a transport error, the next
+	// backend is tried and becomes active once it succeeds. Error responses of the HSM are returned immediately.
+	//
+	// Sessions are bound to the HSM they were established with, so requests are not balanced across backends.
+	// After a failover, session messages of existing sessions are rejected by the new HSM and the sessions have to be
+	// recreated, which the SessionManager does when its keepalive detects them. All HSMs need the same
+	// authentication keys and objects for this to be transparent.
+	FailoverConnector struct {
+		backends []Connector
+		onServed ServedFunc
+
+		lock   sync.Mutex
+		active int
+	}
+)
+
+// ErrNoBackend is returned by a FailoverConnector that has no backends
+var ErrNoBackend = errors.New("failover connector has no backends")
+
+// NewFailoverConnector creates a new instance of FailoverConnector. The first backend is active initially.
+// onServed may be nil.
+func NewFailoverConnector(onServed ServedFunc, backends ...Connector) *FailoverConnector {
+	return &FailoverConnector{
+		backends: backends,
+		onServed: onServed,
+	}
+}
+
+// Request executes a command on the active HSM and returns the binary response
+func (c *FailoverConnector) Request(command *commands.CommandMessage) ([]byte, error) {
+	return c.RequestContext(context.Background(), command)
+}
+
+// RequestContext executes a command on the active HSM and returns the binary response. On transport errors the
+// remaining backends are tried in order. No further backends are tried once ctx is done.
+func (c *FailoverConnector) RequestContext(ctx context.Context, command *commands.CommandMessage) ([]byte, error) {
+	if len(c.backends) == 0 {
+		return nil, ErrNoBackend
+	}
+
+	start := c.Active()
+
+	var err error
+	for i := 0; i < len(c.backends); i++ {
+		backend := (start + i) % len(c.backends)
+
+		var data []byte
+		data, err = c.backends[backend].RequestContext(ctx, command)
+		if c.onServed != nil {
+			c.onServed(backend, command, err)
+		}
+
+		if err == nil {
+			if backend != start {
+				c.lock.Lock()
+				c.active = backend
+				c.lock.Unlock()
+			}
+			return data, nil
+		}
+		if ctx.Err() != nil || !isRetryable(err) {
+			return nil, err
+		}
+	}
+
+	return nil, err
+}
+
+// GetStatus requests the status of the active backend
+func (c *FailoverConnector) GetStatus() (*StatusResponse, error) {
+	if len(c.backends) == 0 {
+		return nil, ErrNoBackend
+	}
+
+	return c.backends[c.Active()].GetStatus()
+}
+
+// Active returns the index of the backend that currently receives the requests
+func (c *FailoverConnector) Active() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.active
+}
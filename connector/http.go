@@ -2,24 +2,52 @@ package connector
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
 
 	"github.com/certusone/yubihsm-go/commands"
 )
 
-var ErrInvalidResponseValueLength = errors.New("invalid response value length")
+var (
+	ErrInvalidResponseValueLength = errors.New("invalid response value length")
+	// ErrMissingStatusValue is returned if the status response of the connector lacks a required value
+	ErrMissingStatusValue = errors.New("connector status is missing a value")
+)
 
 type (
+	// StatusCodeError is returned if the connector responded with a non OK HTTP status code
+	StatusCodeError struct {
+		StatusCode int
+	}
+
 	// HTTPConnector implements the HTTP based connection with the YubiHSM2 connector
 	HTTPConnector struct {
 		URL string
+
+		// client is used for all requests; http.DefaultClient is used if it is nil
+		client *http.Client
+	}
+
+	// requestBody is the body of a request that returns its buffer to requestBuffers once the transport closed it
+	requestBody struct {
+		*bytes.Reader
+		buffer *bytes.Buffer
+		once   sync.Once
 	}
 )
 
+// requestBuffers holds the buffers commands are serialized to, so that requests don't allocate a new one
+var requestBuffers = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
 // NewHTTPConnector creates a new instance of HTTPConnector
 func NewHTTPConnector(url string) *HTTPConnector {
 	return &HTTPConnector{
@@ -27,16 +55,43 @@ func NewHTTPConnector(url string) *HTTPConnector {
 	}
 }
 
+// NewHTTPConnectorWithClient creates a new instance of HTTPConnector that uses client for its requests.
+// Use it to configure timeouts, transports or proxies.
+func NewHTTPConnectorWithClient(url string, client *http.Client) *HTTPConnector {
+	return &HTTPConnector{
+		URL:    url,
+		client: client,
+	}
+}
+
 // Request encodes and executes a command on the HSM and returns the binary response
-func (c *HTTPConnector) Request(command *commands.CommandMessage) (data []byte, err error) {
-	var requestData []byte
-	requestData, err = command.Serialize()
+func (c *HTTPConnector) Request(command *commands.CommandMessage) ([]byte, error) {
+	return c.RequestContext(context.Background(), command)
+}
+
+// RequestContext encodes and executes a command on the HSM and returns the binary response.
+// The HTTP round-trip is aborted once ctx is done.
+func (c *HTTPConnector) RequestContext(ctx context.Context, command *commands.CommandMessage) (data []byte, err error) {
+	buffer := requestBuffers.Get().(*bytes.Buffer)
+	buffer.Reset()
+	err = command.SerializeTo(buffer)
+	if err != nil {
+		requestBuffers.Put(buffer)
+		return
+	}
+	body := &requestBody{Reader: bytes.NewReader(buffer.Bytes()), buffer: buffer}
+
+	var req *http.Request
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, "http://"+c.URL+"/connector/api", body)
 	if err != nil {
+		body.Close()
 		return
 	}
+	req.ContentLength = int64(buffer.Len())
+	req.Header.Set("Content-Type", "application/octet-stream")
 
 	var res *http.Response
-	res, err = http.DefaultClient.Post("http://"+c.URL+"/connector/api", "application/octet-stream", bytes.NewReader(requestData))
+	res, err = c.httpClient().Do(req)
 	if err != nil {
 		return
 	}
@@ -49,7 +104,7 @@ func (c *HTTPConnector) Request(command *commands.CommandMessage) (data []byte,
 	}()
 
 	if res.StatusCode != http.StatusOK {
-		err = fmt.Errorf("server returned non OK status code %d", res.StatusCode)
+		err = &StatusCodeError{StatusCode: res.StatusCode}
 		return
 	}
 
@@ -58,41 +113,79 @@ func (c *HTTPConnector) Request(command *commands.CommandMessage) (data []byte,
 	return
 }
 
+// Close returns the buffer of the body to requestBuffers. The transport may close the body after the request
+// returned, so the buffer must not be reused before.
+func (b *requestBody) Close() error {
+	b.once.Do(func() {
+		requestBuffers.Put(b.buffer)
+	})
+
+	return nil
+}
+
 // GetStatus requests the status of the HSM connector route /connector/status
 func (c *HTTPConnector) GetStatus() (status *StatusResponse, err error) {
 	var res *http.Response
-	res, err = http.DefaultClient.Get("http://" + c.URL + "/connector/status")
+	res, err = c.httpClient().Get("http://" + c.URL + "/connector/status")
 	if err != nil {
 		return
 	}
 
+	defer func() {
+		closeErr := res.Body.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+
+	if res.StatusCode != http.StatusOK {
+		err = &StatusCodeError{StatusCode: res.StatusCode}
+		return
+	}
+
 	var data []byte
 	data, err = ioutil.ReadAll(res.Body)
 	if err != nil {
 		return
 	}
 
-	bodyString := string(data)
-	pairs := strings.Split(bodyString, "\n")
+	values := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		pair := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(pair) != 2 {
+			continue
+		}
+		values[pair[0]] = pair[1]
+	}
 
-	var values []string
-	for _, pair := range pairs {
-		values = append(values, strings.Split(pair, "=")...)
+	for _, key := range []string{"status", "serial", "version", "pid", "address", "port"} {
+		if _, ok := values[key]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrMissingStatusValue, key)
+		}
 	}
 
-	if values == nil || len(values) < 12 {
-		return nil, ErrInvalidResponseValueLength
+	status = &StatusResponse{
+		Status:  Status(values["status"]),
+		Serial:  values["serial"],
+		Version: values["version"],
+		Pid:     values["pid"],
+		Address: values["address"],
+		Port:    values["port"],
 	}
 
-	status = &StatusResponse{}
-	status.Status = Status(values[1])
-	status.Serial = values[3]
-	status.Version = values[5]
-	status.Pid = values[7]
-	status.Address = values[9]
-	status.Port = values[11]
+	return
+}
+
+// httpClient returns the client to be used for requests
+func (c *HTTPConnector) httpClient() *http.Client {
+	if c.client == nil {
+		return http.DefaultClient
+	}
 
-	err = res.Body.Close()
+	return c.client
+}
 
-	return
+// Error formats the StatusCodeError into a human readable format
+func (e *StatusCodeError) Error() string {
+	return fmt.Sprintf("server returned non OK status code %d", e.StatusCode)
 }
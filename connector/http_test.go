@@ -0,0 +1,94 @@
+package connector_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/certusone/yubihsm-go/connector"
+)
+
+func TestHTTPConnectorGetStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		expected   *connector.StatusResponse
+	}{
+		{
+			name:       "valid",
+			statusCode: http.StatusOK,
+			body:       "status=OK\nserial=*\nversion=2.0.0\npid=1234\naddress=localhost\nport=12345\n",
+			expected: &connector.StatusResponse{
+				Status:  connector.StatusOK,
+				Serial:  "*",
+				Version: "2.0.0",
+				Pid:     "1234",
+				Address: "localhost",
+				Port:    "12345",
+			},
+		},
+		{
+			name:       "unordered with unknown values and CRLF",
+			statusCode: http.StatusOK,
+			body:       "port=12345\r\nstatus=NO_DEVICE\r\nextra=1\r\nserial=0000001\r\nversion=2.0.0\r\npid=1\r\naddress=\r\n",
+			expected: &connector.StatusResponse{
+				Status:  connector.StatusNoDevice,
+				Serial:  "0000001",
+				Version: "2.0.0",
+				Pid:     "1",
+				Address: "",
+				Port:    "12345",
+			},
+		},
+		{
+			name:       "missing value",
+			statusCode: http.StatusOK,
+			body:       "status=OK\nserial=*\nversion=2.0.0\n",
+		},
+		{
+			name:       "error page",
+			statusCode: http.StatusOK,
+			body:       "<html>error</html>",
+		},
+		{
+			name:       "error status code",
+			statusCode: http.StatusInternalServerError,
+			body:       "status=OK\nserial=*\nversion=2.0.0\npid=1234\naddress=localhost\nport=12345\n",
+		},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/connector/status" {
+				http.NotFound(w, r)
+				return
+			}
+			w.WriteHeader(test.statusCode)
+			fmt.Fprint(w, test.body)
+		}))
+
+		status, err := connector.NewHTTPConnector(strings.TrimPrefix(server.URL, "http://")).GetStatus()
+		server.Close()
+
+		switch {
+		case test.statusCode != http.StatusOK:
+			var statusErr *connector.StatusCodeError
+			if !errors.As(err, &statusErr) || statusErr.StatusCode != test.statusCode {
+				t.Errorf("%s: expected status code error, got %v", test.name, err)
+			}
+		case test.expected == nil:
+			if !errors.Is(err, connector.ErrMissingStatusValue) {
+				t.Errorf("%s: expected missing value error, got %v", test.name, err)
+			}
+		case err != nil:
+			t.Errorf("%s: requesting status failed: %v", test.name, err)
+		case !reflect.DeepEqual(status, test.expected):
+			t.Errorf("%s: parsed %#v, expected %#v", test.name, status, test.expected)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/certusone/yubihsm-go/commands"
+)
+
+type (
+	// RetryConnector wraps a Connector and retries requests that failed due to network errors or 5xx responses of the
+	// connector, e.g. while the device is busy. Error responses of the HSM are returned immediately.
+	//
+	// A request that failed after reaching the HSM can't be told apart from one that didn't. Retrying a session
+	// message that was already processed by the HSM makes the HSM reject it, so the session has to be recreated.
+	RetryConnector struct {
+		inner      Connector
+		maxRetries int
+		backoff    time.Duration
+	}
+)
+
+// NewRetryConnector creates a new instance of RetryConnector that retries a failed request up to maxRetries times.
+// The delay before the first retry is backoff and doubles with every further retry.
+func NewRetryConnector(inner Connector, maxRetries int, backoff time.Duration) *RetryConnector {
+	return &RetryConnector{
+		inner:      inner,
+		maxRetries: maxRetries,
+		backoff:    backoff,
+	}
+}
+
+// Request executes a command on the HSM and returns the binary response
+func (c *RetryConnector) Request(command *commands.CommandMessage) ([]byte, error) {
+	return c.RequestContext(context.Background(), command)
+}
+
+// RequestContext executes a command on the HSM and returns the binary response.
+// No further attempts are made once ctx is done.
+func (c *RetryConnector) RequestContext(ctx context.Context, command *commands.CommandMessage) ([]byte, error) {
+	delay := c.backoff
+	for attempt := 0; ; attempt++ {
+		data, err := c.inner.RequestContext(ctx, command)
+		if err == nil || attempt >= c.maxRetries || ctx.Err() != nil || !isRetryable(err) {
+			return data, err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+
+		delay *= 2
+	}
+}
+
+// GetStatus requests the status of the wrapped connector
+func (c *RetryConnector) GetStatus() (*StatusResponse, error) {
+	return c.inner.GetStatus()
+}
+
+// isRetryable returns whether err is a transient transport error
+func isRetryable(err error) bool {
+	var statusErr *StatusCodeError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,134 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/certusone/yubihsm-go/commands"
+)
+
+type (
+	// DivergenceFunc is called by the TeeConnector if the secondary connector failed or returned a response that
+	// differs from the response of the primary connector.
+	DivergenceFunc func(command *commands.CommandMessage, primary []byte, secondary []byte, secondaryErr error)
+
+	// TeeConnector forwards requests to a primary and a secondary connector to shadow test an HSM against
+	// production traffic. Only the response of the primary is returned to the caller; the secondary is queried in
+	// the background so that a slow or failing secondary never affects the primary path.
+	//
+	// Only commands that don't use a session, e.g. DeviceInfo or GetDevicePublicKey, are mirrored. Sessions are
+	// bound to the HSM they were established with, so CreateSession, AuthenticateSession and all encrypted
+	// SessionMessages, which carry the actual workload, are only sent to the primary and are not counted by Dropped.
+	// The secondary therefore doesn't see the encrypted workload; shadow it using a separate SessionManager instead.
+	// At most maxShadowRequests requests are mirrored at a time; further requests are not mirrored and counted by
+	// Dropped.
+	TeeConnector struct {
+		// dropped is accessed atomically and kept first in the struct for 64-bit alignment
+		dropped uint64
+
+		primary      Connector
+		secondary    Connector
+		onDivergence DivergenceFunc
+		// shadowSlots limits the number of requests that are mirrored concurrently
+		shadowSlots chan struct{}
+
+		// SecondaryTimeout limits the time spent waiting for the secondary connector
+		SecondaryTimeout time.Duration
+	}
+
+	teeResult struct {
+		data []byte
+		err  error
+	}
+)
+
+const (
+	defaultSecondaryTimeout = 5 * time.Second
+	// maxShadowRequests is the number of requests that are mirrored to the secondary concurrently
+	maxShadowRequests = 16
+)
+
+// NewTeeConnector creates a new instance of TeeConnector. onDivergence is called from a background goroutine.
+func NewTeeConnector(primary, secondary Connector, onDivergence DivergenceFunc) *TeeConnector {
+	return &TeeConnector{
+		primary:          primary,
+		secondary:        secondary,
+		onDivergence:     onDivergence,
+		shadowSlots:      make(chan struct{}, maxShadowRequests),
+		SecondaryTimeout: defaultSecondaryTimeout,
+	}
+}
+
+// Request executes a command on both HSMs and returns the binary response of the primary
+func (c *TeeConnector) Request(command *commands.CommandMessage) ([]byte, error) {
+	return c.RequestContext(context.Background(), command)
+}
+
+// RequestContext executes a command on both HSMs and returns the binary response of the primary.
+// ctx only applies to the primary request.
+func (c *TeeConnector) RequestContext(ctx context.Context, command *commands.CommandMessage) ([]byte, error) {
+	if isSessionBound(command.CommandType) {
+		return c.primary.RequestContext(ctx, command)
+	}
+
+	var primaryResult chan teeResult
+	select {
+	case c.shadowSlots <- struct{}{}:
+		primaryResult = make(chan teeResult, 1)
+		go c.shadow(command, primaryResult)
+	default:
+		// The secondary is lagging behind; don't pile up requests
+		atomic.AddUint64(&c.dropped, 1)
+	}
+
+	data, err := c.primary.RequestContext(ctx, command)
+	if primaryResult != nil {
+		primaryResult <- teeResult{data: data, err: err}
+	}
+
+	return data, err
+}
+
+// Dropped returns the number of requests that were not mirrored because too many mirrored requests were pending
+func (c *TeeConnector) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+// GetStatus requests the status of the primary connector
+func (c *TeeConnector) GetStatus() (*StatusResponse, error) {
+	return c.primary.GetStatus()
+}
+
+// shadow executes command on the secondary connector and reports divergences from the primary result
+func (c *TeeConnector) shadow(command *commands.CommandMessage, primaryResult <-chan teeResult) {
+	defer func() {
+		<-c.shadowSlots
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.SecondaryTimeout)
+	defer cancel()
+
+	secondary, secondaryErr := c.secondary.RequestContext(ctx, command)
+
+	primary := <-primaryResult
+	if primary.err != nil {
+		// There is nothing to compare against
+		return
+	}
+
+	if secondaryErr != nil || !bytes.Equal(primary.data, secondary) {
+		c.onDivergence(command, primary.data, secondary, secondaryErr)
+	}
+}
+
+// isSessionBound reports whether commands of commandType belong to a session with a specific HSM
+func isSessionBound(commandType commands.CommandType) bool {
+	switch commandType {
+	case commands.CommandTypeCreateSession, commands.CommandTypeAuthenticateSession, commands.CommandTypeSessionMessage:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,82 @@
+package connector_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	yubihsm "github.com/certusone/yubihsm-go"
+	"github.com/certusone/yubihsm-go/commands"
+	"github.com/certusone/yubihsm-go/connector"
+	"github.com/certusone/yubihsm-go/fakehsm"
+)
+
+// recordingConnector records the command types it receives and forwards them to a FakeHSM
+type recordingConnector struct {
+	*fakehsm.FakeHSM
+
+	lock     sync.Mutex
+	received []commands.CommandType
+}
+
+func (c *recordingConnector) Request(command *commands.CommandMessage) ([]byte, error) {
+	return c.RequestContext(context.Background(), command)
+}
+
+func (c *recordingConnector) RequestContext(ctx context.Context, command *commands.CommandMessage) ([]byte, error) {
+	c.lock.Lock()
+	c.received = append(c.received, command.CommandType)
+	c.lock.Unlock()
+
+	return c.FakeHSM.RequestContext(ctx, command)
+}
+
+func (c *recordingConnector) commandTypes() []commands.CommandType {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return append([]commands.CommandType(nil), c.received...)
+}
+
+func TestTeeConnectorMirrorsOnlySessionlessCommands(t *testing.T) {
+	primary := fakehsm.New()
+	primary.AddAuthKey(1, "password")
+	secondary := &recordingConnector{FakeHSM: fakehsm.New()}
+
+	tee := connector.NewTeeConnector(primary, secondary, func(*commands.CommandMessage, []byte, []byte, error) {})
+
+	manager, err := yubihsm.NewSessionManager(tee, 1, "password", 1, yubihsm.DisableKeepAlive())
+	if err != nil {
+		t.Fatalf("creating session manager failed: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		_, err = manager.Echo([]byte("echo"))
+		if err != nil {
+			t.Fatalf("echo failed: %v", err)
+		}
+	}
+	_, err = manager.GetDeviceInfo()
+	if err != nil {
+		t.Fatalf("device info failed: %v", err)
+	}
+	manager.Destroy()
+
+	// DeviceInfo is mirrored in the background
+	deadline := time.Now().Add(time.Second)
+	for len(secondary.commandTypes()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("DeviceInfo was not mirrored")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, commandType := range secondary.commandTypes() {
+		if commandType != commands.CommandTypeDeviceInfo {
+			t.Errorf("secondary received session bound command %s", commandType)
+		}
+	}
+	if dropped := tee.Dropped(); dropped != 0 {
+		t.Errorf("expected no dropped requests, got %d", dropped)
+	}
+}
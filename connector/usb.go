@@ -0,0 +1,120 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/certusone/yubihsm-go/commands"
+)
+
+type (
+	// USBConnector implements a direct USB connection with a YubiHSM2 without running the yubihsm-connector daemon.
+	// It is only supported on Linux, where it uses usbfs; the process needs read and write access to the device node
+	// under /dev/bus/usb.
+	USBConnector struct {
+		device usbDevice
+		// lock serializes transfers since a request consists of a write and a read transfer
+		lock sync.Mutex
+	}
+
+	// usbDevice is implemented by the platform specific USB backends
+	usbDevice interface {
+		// write sends data to the bulk out endpoint
+		write(data []byte, timeout time.Duration) error
+		// read receives a message from the bulk in endpoint into buf
+		read(buf []byte, timeout time.Duration) (int, error)
+		close() error
+	}
+)
+
+const (
+	usbVendorID  = 0x1050
+	usbProductID = 0x0030
+
+	usbEndpointOut = 0x01
+	usbEndpointIn  = 0x81
+	usbPacketSize  = 64
+
+	// usbMaxMessageSize is the size of the device's message buffer plus the command header
+	usbMaxMessageSize = 2048 + 3
+)
+
+var ErrUSBStatusNotSupported = errors.New("status is not available for direct USB connections")
+
+// NewUSBConnector opens the YubiHSM2 with the given serial number. If serial is empty, the first YubiHSM2 found is used.
+func NewUSBConnector(serial string) (*USBConnector, error) {
+	device, err := openUSBDevice(serial)
+	if err != nil {
+		return nil, err
+	}
+
+	return &USBConnector{
+		device: device,
+	}, nil
+}
+
+// Request encodes and executes a command on the HSM and returns the binary response
+func (c *USBConnector) Request(command *commands.CommandMessage) ([]byte, error) {
+	return c.RequestContext(context.Background(), command)
+}
+
+// RequestContext encodes and executes a command on the HSM and returns the binary response.
+// USB transfers can't be interrupted, so the deadline of ctx is used as the transfer timeout.
+func (c *USBConnector) RequestContext(ctx context.Context, command *commands.CommandMessage) ([]byte, error) {
+	requestData, err := command.Serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	// A timeout of 0 waits indefinitely
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+		if timeout <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+	}
+
+	err = c.device.write(requestData, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// The device expects a zero length packet to terminate messages that end on a packet boundary
+	if len(requestData)%usbPacketSize == 0 {
+		err = c.device.write(nil, timeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	response := make([]byte, usbMaxMessageSize)
+	n, err := c.device.read(response, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return response[:n], nil
+}
+
+// GetStatus is not supported for direct USB connections and always returns ErrUSBStatusNotSupported
+func (c *USBConnector) GetStatus() (*StatusResponse, error) {
+	return nil, ErrUSBStatusNotSupported
+}
+
+// Close releases the USB device
+func (c *USBConnector) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.device.close()
+}
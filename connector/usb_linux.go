@@ -0,0 +1,173 @@
+package connector
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+type (
+	// linuxUSBDevice accesses a YubiHSM2 using the Linux usbfs interface
+	linuxUSBDevice struct {
+		file *os.File
+	}
+
+	// usbdevfsBulkTransfer mirrors struct usbdevfs_bulktransfer of linux/usbdevice_fs.h
+	usbdevfsBulkTransfer struct {
+		endpoint uint32
+		length   uint32
+		timeout  uint32 // in milliseconds
+		data     unsafe.Pointer
+	}
+)
+
+const (
+	usbSysfsDevices = "/sys/bus/usb/devices"
+	usbInterface    = 0
+)
+
+var (
+	// ioctl request numbers of linux/usbdevice_fs.h
+	usbdevfsBulk             = iowr('U', 2, unsafe.Sizeof(usbdevfsBulkTransfer{}))
+	usbdevfsClaimInterface   = ior('U', 15, unsafe.Sizeof(uint32(0)))
+	usbdevfsReleaseInterface = ior('U', 16, unsafe.Sizeof(uint32(0)))
+)
+
+func ior(t, nr, size uintptr) uintptr {
+	return 2<<30 | size<<16 | t<<8 | nr
+}
+
+func iowr(t, nr, size uintptr) uintptr {
+	return 3<<30 | size<<16 | t<<8 | nr
+}
+
+func openUSBDevice(serial string) (usbDevice, error) {
+	path, err := findUSBDevice(serial)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	device := &linuxUSBDevice{file: file}
+	iface := uint32(usbInterface)
+	err = device.ioctl(usbdevfsClaimInterface, unsafe.Pointer(&iface))
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to claim the USB interface: %v", err)
+	}
+
+	return device, nil
+}
+
+// findUSBDevice returns the usbfs path of the YubiHSM2 with the given serial number or of the first YubiHSM2 found
+// if serial is empty
+func findUSBDevice(serial string) (string, error) {
+	entries, err := ioutil.ReadDir(usbSysfsDevices)
+	if err != nil {
+		return "", err
+	}
+
+	for _, entry := range entries {
+		dir := filepath.Join(usbSysfsDevices, entry.Name())
+		if readSysfsHex(dir, "idVendor") != usbVendorID || readSysfsHex(dir, "idProduct") != usbProductID {
+			continue
+		}
+
+		if serial != "" && strings.TrimLeft(readSysfs(dir, "serial"), "0") != strings.TrimLeft(serial, "0") {
+			continue
+		}
+
+		busNum, err := strconv.Atoi(readSysfs(dir, "busnum"))
+		if err != nil {
+			return "", err
+		}
+		devNum, err := strconv.Atoi(readSysfs(dir, "devnum"))
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("/dev/bus/usb/%03d/%03d", busNum, devNum), nil
+	}
+
+	return "", errors.New("no YubiHSM2 found")
+}
+
+func readSysfs(dir, attribute string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, attribute))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+func readSysfsHex(dir, attribute string) int64 {
+	value, err := strconv.ParseInt(readSysfs(dir, attribute), 16, 32)
+	if err != nil {
+		return -1
+	}
+
+	return value
+}
+
+func (d *linuxUSBDevice) write(data []byte, timeout time.Duration) error {
+	_, err := d.bulk(usbEndpointOut, data, timeout)
+	return err
+}
+
+func (d *linuxUSBDevice) read(buf []byte, timeout time.Duration) (int, error) {
+	return d.bulk(usbEndpointIn, buf, timeout)
+}
+
+func (d *linuxUSBDevice) close() error {
+	iface := uint32(usbInterface)
+	releaseErr := d.ioctl(usbdevfsReleaseInterface, unsafe.Pointer(&iface))
+
+	err := d.file.Close()
+	if err == nil {
+		err = releaseErr
+	}
+
+	return err
+}
+
+// bulk executes a bulk transfer on endpoint and returns the number of transferred bytes
+func (d *linuxUSBDevice) bulk(endpoint uint32, data []byte, timeout time.Duration) (int, error) {
+	transfer := usbdevfsBulkTransfer{
+		endpoint: endpoint,
+		length:   uint32(len(data)),
+		timeout:  uint32(timeout / time.Millisecond),
+	}
+	if len(data) > 0 {
+		transfer.data = unsafe.Pointer(&data[0])
+	}
+
+	n, _, errno := syscall.Syscall(syscall.SYS_IOCTL, d.file.Fd(), usbdevfsBulk, uintptr(unsafe.Pointer(&transfer)))
+	runtime.KeepAlive(data)
+	if errno != 0 {
+		return 0, fmt.Errorf("usb bulk transfer failed: %v", errno)
+	}
+
+	return int(n), nil
+}
+
+func (d *linuxUSBDevice) ioctl(request uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, d.file.Fd(), request, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package connector
+
+import "errors"
+
+func openUSBDevice(serial string) (usbDevice, error) {
+	return nil, errors.New("the USB connector is not supported on this platform")
+}
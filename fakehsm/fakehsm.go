@@ -0,0 +1,368 @@
+// Package fakehsm implements a software YubiHSM2 that can be used as a connector in tests.
+// It implements the SCP03 session handshake and session messages and executes a small set of commands on objects that
+// are kept in memory. It is not meant to be secure and must not be used to store real keys.
+package fakehsm
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/asn1"
+	"encoding/binary"
+	"math/big"
+	"sync"
+
+	"github.com/certusone/yubihsm-go/authkey"
+	"github.com/certusone/yubihsm-go/commands"
+	"github.com/certusone/yubihsm-go/connector"
+)
+
+type (
+	// FakeHSM is an in-memory YubiHSM2 that implements connector.Connector
+	FakeHSM struct {
+		lock     sync.Mutex
+		objects  map[objectID]*object
+		sessions map[uint8]*session
+		// deviceKey is the static key of the device used for asymmetric authentication
+		deviceKey *ecdsa.PrivateKey
+
+		// SerialNumber is reported by DeviceInfo
+		SerialNumber uint32
+	}
+
+	objectID struct {
+		id      uint16
+		objType uint8
+	}
+
+	object struct {
+		label        [commands.LabelLength]byte
+		domains      uint16
+		capabilities uint64
+		algorithm    commands.Algorithm
+		origin       uint8
+
+		// authKey is set for symmetric authentication keys
+		authKey authkey.AuthKey
+		// authPublicKey is set for asymmetric authentication keys and is the static public key of the host
+		authPublicKey *ecdsa.PublicKey
+		// privateKey is set for asymmetric keys and is either ed25519.PrivateKey or *ecdsa.PrivateKey
+		privateKey interface{}
+	}
+)
+
+const (
+	maxSessions = 16
+
+	fakeSerialNumber = 12345678
+)
+
+// New creates a new FakeHSM without any objects. Use AddAuthKey to add an authentication key before creating a
+// session.
+func New() *FakeHSM {
+	deviceKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	return &FakeHSM{
+		objects:      make(map[objectID]*object),
+		sessions:     make(map[uint8]*session),
+		deviceKey:    deviceKey,
+		SerialNumber: fakeSerialNumber,
+	}
+}
+
+// AddAuthKey stores an authentication key derived from password under id
+func (h *FakeHSM) AddAuthKey(id uint16, password string) {
+	h.AddAuthKeyWithKeys(id, authkey.NewFromPassword(password))
+}
+
+// AddAuthKeyWithKeys stores key as authentication key under id
+func (h *FakeHSM) AddAuthKeyWithKeys(id uint16, key authkey.AuthKey) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.objects[objectID{id: id, objType: commands.ObjectTypeAuthenticationKey}] = &object{
+		algorithm: commands.AlgorithmYubicoAESAuthentication,
+		origin:    commands.ObjectOriginImported,
+		authKey:   append(authkey.AuthKey(nil), key...),
+	}
+}
+
+// AddAsymmetricAuthKey stores the static P256 public key of the host as asymmetric authentication key under id
+func (h *FakeHSM) AddAsymmetricAuthKey(id uint16, publicKey *ecdsa.PublicKey) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.objects[objectID{id: id, objType: commands.ObjectTypeAuthenticationKey}] = &object{
+		algorithm:     commands.AlgorithmP256Authentication,
+		origin:        commands.ObjectOriginImported,
+		authPublicKey: publicKey,
+	}
+}
+
+// DevicePublicKey returns the static public key of the device used for asymmetric authentication
+func (h *FakeHSM) DevicePublicKey() *ecdsa.PublicKey {
+	return &h.deviceKey.PublicKey
+}
+
+// Sessions returns the number of open sessions
+func (h *FakeHSM) Sessions() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return len(h.sessions)
+}
+
+// Request executes a command on the fake HSM and returns the binary response
+func (h *FakeHSM) Request(command *commands.CommandMessage) ([]byte, error) {
+	return h.RequestContext(context.Background(), command)
+}
+
+// RequestContext executes a command on the fake HSM and returns the binary response.
+// The fake HSM responds immediately, so ctx is only checked before the command is executed.
+func (h *FakeHSM) RequestContext(ctx context.Context, command *commands.CommandMessage) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Round-trip the command through its wire format like a real connector
+	data, err := command.Serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.handleMessage(data), nil
+}
+
+// GetStatus returns a static status of the fake connector
+func (h *FakeHSM) GetStatus() (*connector.StatusResponse, error) {
+	return &connector.StatusResponse{
+		Status:  connector.StatusOK,
+		Serial:  "*",
+		Version: "fake",
+		Pid:     "0",
+		Address: "localhost",
+		Port:    "0",
+	}, nil
+}
+
+// handleMessage executes a serialized outer command and returns the serialized response
+func (h *FakeHSM) handleMessage(data []byte) []byte {
+	commandType, payload, ok := parseMessage(data)
+	if !ok {
+		return errorResponse(commands.ErrorCodeWrongLength)
+	}
+
+	switch commandType {
+	case commands.CommandTypeCreateSession:
+		return h.createSession(payload)
+	case commands.CommandTypeAuthenticateSession:
+		return h.authenticateSession(payload)
+	case commands.CommandTypeSessionMessage:
+		return h.sessionMessage(payload)
+	case commands.CommandTypeGetDevicePublicKey:
+		publicKey := elliptic.Marshal(h.deviceKey.Curve, h.deviceKey.X, h.deviceKey.Y)
+		return response(commandType, append([]byte{byte(commands.AlgorithmP256Authentication)}, publicKey[1:]...))
+	case commands.CommandTypeDeviceInfo, commands.CommandTypeEcho:
+		return h.execute(commandType, payload)
+	default:
+		return errorResponse(commands.ErrorCodeInvalidCommand)
+	}
+}
+
+// execute executes an unwrapped command and returns the serialized response
+func (h *FakeHSM) execute(commandType commands.CommandType, payload []byte) []byte {
+	switch commandType {
+	case commands.CommandTypeDeviceInfo:
+		info := new(bytes.Buffer)
+		info.Write([]byte{2, 2, 0})
+		binary.Write(info, binary.BigEndian, h.SerialNumber)
+		info.Write([]byte{62, 0})
+		info.Write([]byte{byte(commands.AlgorithmP256), byte(commands.AlgorithmED25519)})
+		return response(commandType, info.Bytes())
+	case commands.CommandTypeEcho:
+		return response(commandType, payload)
+	case commands.CommandTypeGetPseudoRandom:
+		var length uint16
+		if binary.Read(bytes.NewReader(payload), binary.BigEndian, &length) != nil {
+			return errorResponse(commands.ErrorCodeWrongLength)
+		}
+		random := make([]byte, length)
+		rand.Read(random)
+		return response(commandType, random)
+	case commands.CommandTypeGenerateAsymmetricKey:
+		return h.generateAsymmetricKey(payload)
+	case commands.CommandTypeGetPubKey:
+		return h.getPubKey(payload)
+	case commands.CommandTypeSignDataEddsa:
+		return h.sign(commandType, payload)
+	case commands.CommandTypeSignDataEcdsa:
+		return h.sign(commandType, payload)
+	case commands.CommandTypeDeleteObject:
+		return h.deleteObject(payload)
+	default:
+		return errorResponse(commands.ErrorCodeInvalidCommand)
+	}
+}
+
+func (h *FakeHSM) generateAsymmetricKey(payload []byte) []byte {
+	if len(payload) != 2+commands.LabelLength+2+8+1 {
+		return errorResponse(commands.ErrorCodeWrongLength)
+	}
+
+	obj := &object{
+		origin: commands.ObjectOriginGenerated,
+	}
+	id := binary.BigEndian.Uint16(payload[0:2])
+	copy(obj.label[:], payload[2:2+commands.LabelLength])
+	payload = payload[2+commands.LabelLength:]
+	obj.domains = binary.BigEndian.Uint16(payload[0:2])
+	obj.capabilities = binary.BigEndian.Uint64(payload[2:10])
+	obj.algorithm = commands.Algorithm(payload[10])
+
+	var err error
+	switch obj.algorithm {
+	case commands.AlgorithmED25519:
+		_, obj.privateKey, err = ed25519.GenerateKey(rand.Reader)
+	case commands.AlgorithmP256:
+		obj.privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return errorResponse(commands.ErrorCodeInvalidData)
+	}
+	if err != nil {
+		return errorResponse(commands.ErrorCodeStorageFailed)
+	}
+
+	if id == 0 {
+		id = h.freeID(commands.ObjectTypeAsymmetricKey)
+	}
+	key := objectID{id: id, objType: commands.ObjectTypeAsymmetricKey}
+	if _, exists := h.objects[key]; exists {
+		return errorResponse(commands.ErrorCodeObjectExists)
+	}
+	h.objects[key] = obj
+
+	idBytes := make([]byte, 2)
+	binary.BigEndian.PutUint16(idBytes, id)
+	return response(commands.CommandTypeGenerateAsymmetricKey, idBytes)
+}
+
+func (h *FakeHSM) getPubKey(payload []byte) []byte {
+	if len(payload) != 2 {
+		return errorResponse(commands.ErrorCodeWrongLength)
+	}
+
+	obj, found := h.objects[objectID{id: binary.BigEndian.Uint16(payload), objType: commands.ObjectTypeAsymmetricKey}]
+	if !found {
+		return errorResponse(commands.ErrorCodeObjectNotFound)
+	}
+
+	keyData := []byte{byte(obj.algorithm)}
+	switch k := obj.privateKey.(type) {
+	case ed25519.PrivateKey:
+		keyData = append(keyData, k.Public().(ed25519.PublicKey)...)
+	case *ecdsa.PrivateKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		point := elliptic.Marshal(k.Curve, k.X, k.Y)
+		keyData = append(keyData, point[1:1+2*size]...)
+	}
+
+	return response(commands.CommandTypeGetPubKey, keyData)
+}
+
+func (h *FakeHSM) sign(commandType commands.CommandType, payload []byte) []byte {
+	if len(payload) < 2 {
+		return errorResponse(commands.ErrorCodeWrongLength)
+	}
+
+	obj, found := h.objects[objectID{id: binary.BigEndian.Uint16(payload), objType: commands.ObjectTypeAsymmetricKey}]
+	if !found {
+		return errorResponse(commands.ErrorCodeObjectNotFound)
+	}
+
+	var signature []byte
+	var err error
+	switch k := obj.privateKey.(type) {
+	case ed25519.PrivateKey:
+		if commandType != commands.CommandTypeSignDataEddsa || obj.capabilities&commands.CapabilityAsymmetricSignEddsa == 0 {
+			return errorResponse(commands.ErrorCodeInvalidPermission)
+		}
+		signature = ed25519.Sign(k, payload[2:])
+	case *ecdsa.PrivateKey:
+		if commandType != commands.CommandTypeSignDataEcdsa || obj.capabilities&commands.CapabilityAsymmetricSignEcdsa == 0 {
+			return errorResponse(commands.ErrorCodeInvalidPermission)
+		}
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, payload[2:])
+		if err == nil {
+			signature, err = asn1.Marshal(struct{ R, S *big.Int }{r, s})
+		}
+	}
+	if err != nil {
+		return errorResponse(commands.ErrorCodeInvalidData)
+	}
+
+	return response(commandType, signature)
+}
+
+func (h *FakeHSM) deleteObject(payload []byte) []byte {
+	if len(payload) != 3 {
+		return errorResponse(commands.ErrorCodeWrongLength)
+	}
+
+	key := objectID{id: binary.BigEndian.Uint16(payload[0:2]), objType: payload[2]}
+	if _, found := h.objects[key]; !found {
+		return errorResponse(commands.ErrorCodeObjectNotFound)
+	}
+	delete(h.objects, key)
+
+	return response(commands.CommandTypeDeleteObject, nil)
+}
+
+// freeID returns the lowest unused ID for objects of objType
+func (h *FakeHSM) freeID(objType uint8) uint16 {
+	for id := uint16(1); id != 0; id++ {
+		if _, exists := h.objects[objectID{id: id, objType: objType}]; !exists {
+			return id
+		}
+	}
+
+	return 0
+}
+
+// parseMessage splits a serialized message into its command type and payload
+func parseMessage(data []byte) (commands.CommandType, []byte, bool) {
+	if len(data) < 3 {
+		return 0, nil, false
+	}
+
+	length := binary.BigEndian.Uint16(data[1:3])
+	if len(data) != 3+int(length) {
+		return 0, nil, false
+	}
+
+	return commands.CommandType(data[0]), data[3:], true
+}
+
+// response serializes the response to a command of commandType
+func response(commandType commands.CommandType, payload []byte) []byte {
+	buffer := new(bytes.Buffer)
+	buffer.WriteByte(byte(commandType) | commands.ResponseCommandOffset)
+	binary.Write(buffer, binary.BigEndian, uint16(len(payload)))
+	buffer.Write(payload)
+
+	return buffer.Bytes()
+}
+
+// errorResponse serializes an error response with code
+func errorResponse(code commands.ErrorCode) []byte {
+	return []byte{byte(commands.ErrorResponseCode - commands.ResponseCommandOffset), 0x00, 0x01, byte(code)}
+}
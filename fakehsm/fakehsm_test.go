@@ -0,0 +1,465 @@
+package fakehsm_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	yubihsm "github.com/certusone/yubihsm-go"
+	"github.com/certusone/yubihsm-go/commands"
+	"github.com/certusone/yubihsm-go/connector"
+	"github.com/certusone/yubihsm-go/fakehsm"
+	"github.com/certusone/yubihsm-go/securechannel"
+)
+
+const (
+	testAuthKeyID = 1
+	testPassword  = "password"
+)
+
+// failingConnector fails all requests of commandType with err
+type failingConnector struct {
+	*fakehsm.FakeHSM
+	commandType commands.CommandType
+	err         error
+}
+
+func (c *failingConnector) Request(command *commands.CommandMessage) ([]byte, error) {
+	return c.RequestContext(context.Background(), command)
+}
+
+func (c *failingConnector) RequestContext(ctx context.Context, command *commands.CommandMessage) ([]byte, error) {
+	if command.CommandType == c.commandType {
+		return nil, c.err
+	}
+
+	return c.FakeHSM.RequestContext(ctx, command)
+}
+
+// stallingConnector blocks CreateSession requests until their context is done once stall is set to 1
+type stallingConnector struct {
+	*fakehsm.FakeHSM
+	stall uint32
+}
+
+func (c *stallingConnector) Request(command *commands.CommandMessage) ([]byte, error) {
+	return c.RequestContext(context.Background(), command)
+}
+
+func (c *stallingConnector) RequestContext(ctx context.Context, command *commands.CommandMessage) ([]byte, error) {
+	if command.CommandType == commands.CommandTypeCreateSession && atomic.LoadUint32(&c.stall) == 1 {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	return c.FakeHSM.RequestContext(ctx, command)
+}
+
+// tamperingConnector flips the last byte of all responses to commandType
+type tamperingConnector struct {
+	*fakehsm.FakeHSM
+	commandType commands.CommandType
+}
+
+func (c *tamperingConnector) Request(command *commands.CommandMessage) ([]byte, error) {
+	return c.RequestContext(context.Background(), command)
+}
+
+func (c *tamperingConnector) RequestContext(ctx context.Context, command *commands.CommandMessage) ([]byte, error) {
+	data, err := c.FakeHSM.RequestContext(ctx, command)
+	if err == nil && command.CommandType == c.commandType {
+		data[len(data)-1] ^= 0xff
+	}
+
+	return data, err
+}
+
+// countingConnector counts the CreateSession requests forwarded to a FakeHSM
+type countingConnector struct {
+	*fakehsm.FakeHSM
+	createSessions uint32
+}
+
+func (c *countingConnector) Request(command *commands.CommandMessage) ([]byte, error) {
+	return c.RequestContext(context.Background(), command)
+}
+
+func (c *countingConnector) RequestContext(ctx context.Context, command *commands.CommandMessage) ([]byte, error) {
+	if command.CommandType == commands.CommandTypeCreateSession {
+		atomic.AddUint32(&c.createSessions, 1)
+	}
+
+	return c.FakeHSM.RequestContext(ctx, command)
+}
+
+func newTestHSM() *fakehsm.FakeHSM {
+	hsm := fakehsm.New()
+	hsm.AddAuthKey(testAuthKeyID, testPassword)
+
+	return hsm
+}
+
+func TestSessionManagerRoundTrip(t *testing.T) {
+	hsm := newTestHSM()
+
+	manager, err := yubihsm.NewSessionManager(hsm, testAuthKeyID, testPassword, 2, yubihsm.DisableKeepAlive())
+	if err != nil {
+		t.Fatalf("creating session manager failed: %v", err)
+	}
+	if sessions := hsm.Sessions(); sessions != 2 {
+		t.Fatalf("expected 2 authenticated sessions, got %d", sessions)
+	}
+
+	data := []byte("echo")
+	echoed, err := manager.Echo(data)
+	if err != nil {
+		t.Fatalf("echo failed: %v", err)
+	}
+	if !bytes.Equal(echoed, data) {
+		t.Fatalf("echo returned %x, expected %x", echoed, data)
+	}
+
+	client := yubihsm.NewClient(manager)
+	keyID, err := client.GenerateEd25519Key(0, "test", 1, commands.CapabilityAsymmetricSignEddsa)
+	if err != nil {
+		t.Fatalf("generating key failed: %v", err)
+	}
+	publicKey, err := client.GetPublicKey(keyID)
+	if err != nil {
+		t.Fatalf("getting public key failed: %v", err)
+	}
+
+	message := []byte("message")
+	signature, err := client.SignEddsa(keyID, message)
+	if err != nil {
+		t.Fatalf("signing failed: %v", err)
+	}
+	if !yubihsm.VerifyEddsa(publicKey.(ed25519.PublicKey), message, signature) {
+		t.Fatal("signature is invalid")
+	}
+
+	manager.Destroy()
+	if sessions := hsm.Sessions(); sessions != 0 {
+		t.Fatalf("expected all sessions to be closed, %d are left", sessions)
+	}
+}
+
+func TestTimeoutCoversReauthentication(t *testing.T) {
+	conn := &stallingConnector{FakeHSM: newTestHSM()}
+
+	const timeout = 100 * time.Millisecond
+	manager, err := yubihsm.NewSessionManager(conn, testAuthKeyID, testPassword, 1, yubihsm.DisableKeepAlive(),
+		yubihsm.WithMaxMessagesPerSession(4), yubihsm.WithTimeout(timeout))
+	if err != nil {
+		t.Fatalf("creating session manager failed: %v", err)
+	}
+	atomic.StoreUint32(&conn.stall, 1)
+
+	// The session is exhausted after a few messages, so a command has to wait for the stalled re-authentication
+	for i := 0; ; i++ {
+		start := time.Now()
+		_, err = manager.Echo([]byte("echo"))
+		if elapsed := time.Since(start); elapsed > 10*timeout {
+			t.Fatalf("echo took %v despite the timeout of %v", elapsed, timeout)
+		}
+		if err != nil {
+			break
+		}
+		if i == 10 {
+			t.Fatal("the session was not exhausted")
+		}
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// Destroy aborts the stalled swap running in the background
+	done := make(chan struct{})
+	go func() {
+		manager.Destroy()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Destroy blocked on the stalled swap")
+	}
+}
+
+func TestAsymmetricAuthentication(t *testing.T) {
+	hsm := newTestHSM()
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hsm.AddAsymmetricAuthKey(2, &hostKey.PublicKey)
+
+	devicePublicKey, err := securechannel.GetDevicePublicKey(hsm)
+	if err != nil {
+		t.Fatalf("getting device public key failed: %v", err)
+	}
+	if devicePublicKey.X.Cmp(hsm.DevicePublicKey().X) != 0 || devicePublicKey.Y.Cmp(hsm.DevicePublicKey().Y) != 0 {
+		t.Fatal("device public key does not match")
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		connector       connector.Connector
+		privateKey      *ecdsa.PrivateKey
+		devicePublicKey *ecdsa.PublicKey
+		wantErr         error
+	}{
+		{"valid", hsm, hostKey, devicePublicKey, nil},
+		{"bad receipt", &tamperingConnector{FakeHSM: hsm, commandType: commands.CommandTypeCreateSession}, hostKey, devicePublicKey, securechannel.ErrAuthReceipt},
+		{"wrong device key", hsm, hostKey, &otherKey.PublicKey, securechannel.ErrAuthReceipt},
+		{"wrong host key", hsm, otherKey, devicePublicKey, securechannel.ErrAuthReceipt},
+	}
+
+	for _, test := range tests {
+		channel, err := securechannel.NewSecureChannelAsymmetric(test.connector, 2, test.privateKey, test.devicePublicKey)
+		if err != nil {
+			t.Fatalf("%s: creating channel failed: %v", test.name, err)
+		}
+
+		err = channel.Authenticate()
+		if test.wantErr != nil {
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("%s: expected %v, got %v", test.name, test.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: authentication failed: %v", test.name, err)
+		}
+
+		command, err := commands.CreateEchoCommand([]byte("echo"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := channel.SendEncryptedCommand(command)
+		if err != nil {
+			t.Fatalf("%s: echo failed: %v", test.name, err)
+		}
+		if echo, ok := resp.(*commands.EchoResponse); !ok || !bytes.Equal(echo.Data, []byte("echo")) {
+			t.Fatalf("%s: unexpected echo response %v", test.name, resp)
+		}
+		err = channel.Close()
+		if err != nil {
+			t.Fatalf("%s: closing failed: %v", test.name, err)
+		}
+	}
+}
+
+func TestAsymmetricChannelInvalidKeys(t *testing.T) {
+	hsm := newTestHSM()
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offCurve := &ecdsa.PublicKey{Curve: elliptic.P256(), X: big.NewInt(1), Y: big.NewInt(1)}
+
+	tests := []struct {
+		name            string
+		privateKey      *ecdsa.PrivateKey
+		devicePublicKey *ecdsa.PublicKey
+	}{
+		{"nil private key", nil, hsm.DevicePublicKey()},
+		{"nil device key", hostKey, nil},
+		{"wrong curve", p384Key, hsm.DevicePublicKey()},
+		{"device key not on curve", hostKey, offCurve},
+	}
+
+	for _, test := range tests {
+		_, err := securechannel.NewSecureChannelAsymmetric(hsm, 2, test.privateKey, test.devicePublicKey)
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestSecureChannelConcurrentCloseAndSend(t *testing.T) {
+	hsm := newTestHSM()
+
+	channel, err := securechannel.NewSecureChannel(hsm, testAuthKeyID, testPassword)
+	if err != nil {
+		t.Fatalf("creating channel failed: %v", err)
+	}
+	err = channel.Authenticate()
+	if err != nil {
+		t.Fatalf("authentication failed: %v", err)
+	}
+
+	command, err := commands.CreateEchoCommand([]byte("echo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				// Commands racing with Close either succeed or fail cleanly; they must never panic
+				channel.SendEncryptedCommand(command)
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		channel.Close()
+	}()
+	wg.Wait()
+
+	_, err = channel.SendEncryptedCommand(command)
+	if err == nil {
+		t.Fatal("sending a command on a closed channel succeeded")
+	}
+	if sessions := hsm.Sessions(); sessions != 0 {
+		t.Fatalf("expected the session to be closed, %d are left", sessions)
+	}
+}
+
+func TestSessionSlotsInUse(t *testing.T) {
+	tests := []struct {
+		name     string
+		poolSize uint
+		leaked   int
+		// probes is the number of sessions the probe tries to create
+		probes uint32
+	}{
+		{name: "no leaked sessions", poolSize: 2, leaked: 0, probes: yubihsm.MaxSessions - 2},
+		{name: "leaked sessions", poolSize: 2, leaked: 3, probes: yubihsm.MaxSessions - 5 + 1},
+		{name: "all slots in use", poolSize: 4, leaked: yubihsm.MaxSessions - 4, probes: 1},
+	}
+
+	for _, test := range tests {
+		conn := &countingConnector{FakeHSM: newTestHSM()}
+		manager, err := yubihsm.NewSessionManager(conn, testAuthKeyID, testPassword, test.poolSize, yubihsm.DisableKeepAlive())
+		if err != nil {
+			t.Fatalf("%s: creating session manager failed: %v", test.name, err)
+		}
+
+		for i := 0; i < test.leaked; i++ {
+			channel, err := securechannel.NewSecureChannel(conn.FakeHSM, testAuthKeyID, testPassword)
+			if err != nil {
+				t.Fatalf("%s: creating channel failed: %v", test.name, err)
+			}
+			if err = channel.Authenticate(); err != nil {
+				t.Fatalf("%s: authenticating channel failed: %v", test.name, err)
+			}
+		}
+
+		atomic.StoreUint32(&conn.createSessions, 0)
+		inUse, err := manager.SessionSlotsInUse()
+		if err != nil {
+			t.Errorf("%s: probing session slots failed: %v", test.name, err)
+		} else if expected := int(test.poolSize) + test.leaked; inUse != expected {
+			t.Errorf("%s: %d session slots in use, expected %d", test.name, inUse, expected)
+		}
+		if probes := atomic.LoadUint32(&conn.createSessions); probes != test.probes {
+			t.Errorf("%s: created %d probe sessions, expected %d", test.name, probes, test.probes)
+		}
+		if sessions := conn.Sessions(); sessions != int(test.poolSize)+test.leaked {
+			t.Errorf("%s: %d sessions left open after probing", test.name, sessions)
+		}
+
+		manager.Destroy()
+	}
+}
+
+func TestAuthenticateKeepsCause(t *testing.T) {
+	cause := errors.New("connection reset")
+	conn := &failingConnector{FakeHSM: newTestHSM(), commandType: commands.CommandTypeAuthenticateSession, err: cause}
+
+	channel, err := securechannel.NewSecureChannel(conn, testAuthKeyID, testPassword)
+	if err != nil {
+		t.Fatalf("creating channel failed: %v", err)
+	}
+
+	err = channel.Authenticate()
+	if !errors.Is(err, securechannel.ErrAuthFailed) {
+		t.Fatalf("expected ErrAuthFailed, got %v", err)
+	}
+	var connectorErr *securechannel.ConnectorError
+	if !errors.As(err, &connectorErr) || connectorErr.Err != cause {
+		t.Fatalf("expected the connector error to be preserved, got %v", err)
+	}
+}
+
+func BenchmarkSendEncryptedCommand(b *testing.B) {
+	hsm := newTestHSM()
+
+	channel, err := securechannel.NewSecureChannel(hsm, testAuthKeyID, testPassword, securechannel.WithMaxMessages(^uint32(0)))
+	if err != nil {
+		b.Fatalf("creating channel failed: %v", err)
+	}
+	err = channel.Authenticate()
+	if err != nil {
+		b.Fatalf("authentication failed: %v", err)
+	}
+	defer channel.Close()
+
+	command, err := commands.CreateEchoCommand(make([]byte, 64))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err = channel.SendEncryptedCommand(command)
+		if err != nil {
+			b.Fatalf("sending command failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkSessionManagerParallel(b *testing.B) {
+	for _, poolSize := range []uint{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("poolSize=%d", poolSize), func(b *testing.B) {
+			manager, err := yubihsm.NewSessionManager(newTestHSM(), testAuthKeyID, testPassword, poolSize, yubihsm.DisableKeepAlive())
+			if err != nil {
+				b.Fatalf("creating session manager failed: %v", err)
+			}
+			defer manager.Destroy()
+
+			command, err := commands.CreateEchoCommand(make([]byte, 64))
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_, err := manager.SendEncryptedCommand(command)
+					if err != nil {
+						b.Errorf("sending command failed: %v", err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
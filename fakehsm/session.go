@@ -0,0 +1,311 @@
+package fakehsm
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"math/big"
+
+	"github.com/certusone/yubihsm-go/authkey"
+	"github.com/certusone/yubihsm-go/commands"
+	"github.com/certusone/yubihsm-go/securechannel"
+	"github.com/enceve/crypto/cmac"
+)
+
+type (
+	// session is the device side state of an SCP03 or SCP11 session
+	session struct {
+		authKey         authkey.AuthKey
+		hostChallenge   []byte
+		deviceChallenge []byte
+		encKey          []byte
+		macKey          []byte
+		rmacKey         []byte
+		macChainValue   []byte
+		counter         uint32
+		authenticated   bool
+	}
+)
+
+// asymmetricSharedInfo is the shared info of the X9.63 KDF of SCP11 sessions
+var asymmetricSharedInfo = []byte{0x3c, 0x88, 0x10}
+
+// createSession handles CreateSession by deriving the session keys and the device cryptogram
+func (h *FakeHSM) createSession(payload []byte) []byte {
+	if len(payload) == 2+65 {
+		return h.createSessionAsymmetric(payload)
+	}
+	if len(payload) != 2+securechannel.ChallengeLength {
+		return errorResponse(commands.ErrorCodeWrongLength)
+	}
+
+	obj, found := h.objects[objectID{id: binary.BigEndian.Uint16(payload), objType: commands.ObjectTypeAuthenticationKey}]
+	if !found {
+		return errorResponse(commands.ErrorCodeObjectNotFound)
+	}
+	if obj.authKey == nil {
+		return errorResponse(commands.ErrorCodeInvalidData)
+	}
+
+	id, found := h.freeSessionID()
+	if !found {
+		return errorResponse(commands.ErrorCodeSessionFull)
+	}
+
+	s := &session{
+		authKey:         obj.authKey,
+		hostChallenge:   append([]byte(nil), payload[2:]...),
+		deviceChallenge: make([]byte, securechannel.ChallengeLength),
+		macChainValue:   make([]byte, 16),
+	}
+	rand.Read(s.deviceChallenge)
+
+	s.encKey = s.deriveKDF(s.authKey.GetEncKey(), securechannel.DerivationConstantEncKey, securechannel.KeyLength)
+	s.macKey = s.deriveKDF(s.authKey.GetMacKey(), securechannel.DerivationConstantMACKey, securechannel.KeyLength)
+	s.rmacKey = s.deriveKDF(s.authKey.GetMacKey(), securechannel.DerivationConstantRMACKey, securechannel.KeyLength)
+	deviceCryptogram := s.deriveKDF(s.macKey, securechannel.DerivationConstantDeviceCryptogram, securechannel.CryptogramLength)
+
+	h.sessions[id] = s
+
+	resp := append([]byte{id}, s.deviceChallenge...)
+	resp = append(resp, deviceCryptogram...)
+	return response(commands.CommandTypeCreateSession, resp)
+}
+
+// createSessionAsymmetric handles CreateSession for asymmetric authentication keys (SCP11). The session keys are
+// derived from the ephemeral and the static ECDH shared secrets and the session is authenticated immediately.
+func (h *FakeHSM) createSessionAsymmetric(payload []byte) []byte {
+	obj, found := h.objects[objectID{id: binary.BigEndian.Uint16(payload), objType: commands.ObjectTypeAuthenticationKey}]
+	if !found {
+		return errorResponse(commands.ErrorCodeObjectNotFound)
+	}
+	if obj.authPublicKey == nil {
+		return errorResponse(commands.ErrorCodeInvalidData)
+	}
+
+	curve := elliptic.P256()
+	hostPublicKey := payload[2:]
+	hostX, hostY := elliptic.Unmarshal(curve, hostPublicKey)
+	if hostX == nil {
+		return errorResponse(commands.ErrorCodeInvalidData)
+	}
+
+	id, found := h.freeSessionID()
+	if !found {
+		return errorResponse(commands.ErrorCodeSessionFull)
+	}
+
+	ephemeralKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return errorResponse(commands.ErrorCodeInvalidData)
+	}
+	cardPublicKey := elliptic.Marshal(curve, ephemeralKey.X, ephemeralKey.Y)
+
+	sharedSecret := append(ecdhSharedSecret(curve, ephemeralKey.D, hostX, hostY),
+		ecdhSharedSecret(curve, h.deviceKey.D, obj.authPublicKey.X, obj.authPublicKey.Y)...)
+	keys := x963KDF(sharedSecret, asymmetricSharedInfo, 4*securechannel.KeyLength)
+
+	block, _ := aes.NewCipher(keys[:securechannel.KeyLength])
+	mac, _ := cmac.New(block)
+	mac.Write(hostPublicKey)
+	mac.Write(cardPublicKey)
+	receipt := mac.Sum(nil)
+
+	h.sessions[id] = &session{
+		encKey:        keys[securechannel.KeyLength : 2*securechannel.KeyLength],
+		macKey:        keys[2*securechannel.KeyLength : 3*securechannel.KeyLength],
+		rmacKey:       keys[3*securechannel.KeyLength:],
+		macChainValue: receipt,
+		counter:       1,
+		authenticated: true,
+	}
+
+	resp := append([]byte{id}, cardPublicKey...)
+	resp = append(resp, receipt...)
+	return response(commands.CommandTypeCreateSession, resp)
+}
+
+// authenticateSession handles AuthenticateSession by verifying the MAC and the host cryptogram
+func (h *FakeHSM) authenticateSession(payload []byte) []byte {
+	id, s, data, ok := h.verifySessionCommand(commands.CommandTypeAuthenticateSession, payload)
+	if !ok {
+		return errorResponse(commands.ErrorCodeInvalidSession)
+	}
+
+	hostCryptogram := s.deriveKDF(s.macKey, securechannel.DerivationConstantHostCryptogram, securechannel.CryptogramLength)
+	if s.authenticated || subtle.ConstantTimeCompare(hostCryptogram, data) != 1 {
+		delete(h.sessions, id)
+		return errorResponse(commands.ErrorCodeAuthFail)
+	}
+
+	s.authenticated = true
+	s.counter = 1
+
+	return response(commands.CommandTypeAuthenticateSession, nil)
+}
+
+// sessionMessage handles SessionMessage by decrypting, executing and encrypting the wrapped command
+func (h *FakeHSM) sessionMessage(payload []byte) []byte {
+	id, s, encryptedCommand, ok := h.verifySessionCommand(commands.CommandTypeSessionMessage, payload)
+	if !ok || !s.authenticated {
+		return errorResponse(commands.ErrorCodeInvalidSession)
+	}
+	if len(encryptedCommand) == 0 || len(encryptedCommand)%aes.BlockSize != 0 {
+		return errorResponse(commands.ErrorCodeInvalidData)
+	}
+
+	block, _ := aes.NewCipher(s.encKey)
+
+	icv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint32(icv[12:], s.counter)
+	iv := make([]byte, aes.BlockSize)
+	block.Encrypt(iv, icv)
+
+	decryptedCommand := make([]byte, len(encryptedCommand))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decryptedCommand, encryptedCommand)
+
+	var innerResponse []byte
+	commandType, innerPayload, ok := parseMessage(unpad(decryptedCommand))
+	switch {
+	case !ok:
+		innerResponse = errorResponse(commands.ErrorCodeWrongLength)
+	case commandType == commands.CommandTypeCloseSession:
+		innerResponse = response(commandType, nil)
+	default:
+		innerResponse = h.execute(commandType, innerPayload)
+	}
+
+	encryptedResponse := pad(innerResponse)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encryptedResponse, encryptedResponse)
+
+	s.counter++
+
+	mac := s.calculateMAC(s.rmacKey, commands.CommandTypeSessionMessage|commands.ResponseCommandOffset, id, encryptedResponse)
+
+	if commandType == commands.CommandTypeCloseSession {
+		delete(h.sessions, id)
+	}
+
+	resp := append([]byte{id}, encryptedResponse...)
+	resp = append(resp, mac[:securechannel.MACLength]...)
+	return response(commands.CommandTypeSessionMessage, resp)
+}
+
+// verifySessionCommand looks up the session of a MAC authenticated command, verifies the MAC and advances the MAC
+// chain. It returns the session ID, the session and the data of the command.
+func (h *FakeHSM) verifySessionCommand(commandType commands.CommandType, payload []byte) (uint8, *session, []byte, bool) {
+	if len(payload) < 1+securechannel.MACLength {
+		return 0, nil, nil, false
+	}
+
+	id := payload[0]
+	s, found := h.sessions[id]
+	if !found {
+		return 0, nil, nil, false
+	}
+
+	data := payload[1 : len(payload)-securechannel.MACLength]
+	mac := s.calculateMAC(s.macKey, commandType, id, data)
+	if subtle.ConstantTimeCompare(mac[:securechannel.MACLength], payload[len(payload)-securechannel.MACLength:]) != 1 {
+		return 0, nil, nil, false
+	}
+	s.macChainValue = mac
+
+	return id, s, data, true
+}
+
+// freeSessionID returns the lowest unused session ID
+func (h *FakeHSM) freeSessionID() (uint8, bool) {
+	for id := uint8(0); id < maxSessions; id++ {
+		if _, exists := h.sessions[id]; !exists {
+			return id, true
+		}
+	}
+
+	return 0, false
+}
+
+// calculateMAC calculates the full CMAC of a session command or response using the current MAC chain value
+func (s *session) calculateMAC(key []byte, commandType commands.CommandType, id uint8, data []byte) []byte {
+	block, _ := aes.NewCipher(key)
+	mac, _ := cmac.New(block)
+
+	buffer := new(bytes.Buffer)
+	buffer.Write(s.macChainValue)
+	buffer.WriteByte(byte(commandType))
+	binary.Write(buffer, binary.BigEndian, uint16(1+len(data)+securechannel.MACLength))
+	buffer.WriteByte(id)
+	buffer.Write(data)
+
+	mac.Write(buffer.Bytes())
+	return mac.Sum(nil)
+}
+
+// deriveKDF derives a key or cryptogram using SCP03's KDF
+func (s *session) deriveKDF(key []byte, derivationConstant securechannel.KeyDerivationConstant, length uint8) []byte {
+	derivationData := new(bytes.Buffer)
+	derivationData.Write(make([]byte, 11))
+	derivationData.WriteByte(byte(derivationConstant))
+	derivationData.WriteByte(0x00)
+	binary.Write(derivationData, binary.BigEndian, uint16(length)*8)
+	derivationData.WriteByte(0x01)
+	derivationData.Write(s.hostChallenge)
+	derivationData.Write(s.deviceChallenge)
+
+	block, _ := aes.NewCipher(key)
+	mac, _ := cmac.New(block)
+	mac.Write(derivationData.Bytes())
+
+	return mac.Sum(nil)[:length]
+}
+
+// ecdhSharedSecret returns the x coordinate of d*(x, y) padded to the size of the curve
+func ecdhSharedSecret(curve elliptic.Curve, d *big.Int, x, y *big.Int) []byte {
+	sharedX, _ := curve.ScalarMult(x, y, d.Bytes())
+
+	secret := make([]byte, (curve.Params().BitSize+7)/8)
+	b := sharedX.Bytes()
+	copy(secret[len(secret)-len(b):], b)
+
+	return secret
+}
+
+// x963KDF derives length bytes from secret using the ANSI X9.63 KDF with SHA256
+func x963KDF(secret, sharedInfo []byte, length int) []byte {
+	var out []byte
+	for i := uint32(1); len(out) < length; i++ {
+		h := sha256.New()
+		h.Write(secret)
+		binary.Write(h, binary.BigEndian, i)
+		h.Write(sharedInfo)
+		out = h.Sum(out)
+	}
+
+	return out[:length]
+}
+
+// pad adds the SCP03 padding to src
+func pad(src []byte) []byte {
+	padded := append(append([]byte(nil), src...), 0x80)
+	for len(padded)%aes.BlockSize != 0 {
+		padded = append(padded, 0x00)
+	}
+
+	return padded
+}
+
+// unpad removes the SCP03 padding from src
+func unpad(src []byte) []byte {
+	i := bytes.LastIndexByte(src, 0x80)
+	if i < 0 {
+		return src
+	}
+
+	return src[:i]
+}
@@ -0,0 +1,71 @@
+package yubihsm
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/certusone/yubihsm-go/commands"
+)
+
+// ImportKeyFromPEM parses a PEM encoded EC, Ed25519 or RSA private key and stores it on the HSM using PutAsymmetricKey.
+// PKCS8 ("PRIVATE KEY"), SEC1 ("EC PRIVATE KEY") and PKCS1 ("RSA PRIVATE KEY") blocks are supported.
+// The algorithm and key parts are derived from the key and the capabilities are validated against the key type.
+// It returns the ID of the imported object.
+func (s *SessionManager) ImportKeyFromPEM(objID uint16, label string, domains uint16, capabilities uint64, pemBytes []byte) (uint16, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return 0, errors.New("no PEM data found")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return 0, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	command, err := createPutKeyCommand(objID, label, domains, capabilities, key)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := s.SendEncryptedCommand(command)
+	if err != nil {
+		return 0, err
+	}
+
+	parsedResp, matched := resp.(*commands.PutAsymmetricKeyResponse)
+	if !matched {
+		return 0, ErrInvalidResponseType
+	}
+
+	return parsedResp.KeyID, nil
+}
+
+// createPutKeyCommand creates the PutAsymmetricKey command for a private key
+func createPutKeyCommand(objID uint16, label string, domains uint16, capabilities uint64, key interface{}) (*commands.CommandMessage, error) {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return commands.CreatePutECKeyCommand(objID, []byte(label), domains, capabilities, k)
+	case ed25519.PrivateKey:
+		return commands.CreatePutEd25519KeyCommand(objID, []byte(label), domains, capabilities, k)
+	case *rsa.PrivateKey:
+		return commands.CreatePutRSAKeyCommand(objID, []byte(label), domains, capabilities, k)
+	default:
+		return nil, errors.New("unsupported private key type")
+	}
+}
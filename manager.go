@@ -2,7 +2,11 @@ package yubihsm
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/x509"
 	"errors"
+	"fmt"
 	"log"
 	"sync"
 	"time"
@@ -15,148 +19,900 @@ import (
 type (
 	// SessionManager manages a pool of authenticated secure sessions with a YubiHSM2
 	SessionManager struct {
-		session   *securechannel.SecureChannel
-		lock      sync.Mutex
+		sessions []*securechannel.SecureChannel
+		// next is the index of the session to be used for the next command
+		next int
+		// lock is a semaphore rather than a sync.Mutex so that acquiring it can be aborted using a context.
+		// It is only held for bookkeeping and never during a round-trip to the HSM, so bookkeeping that must not be
+		// skipped, e.g. releasing a session, acquires it without a deadline.
+		lock      chan struct{}
 		connector connector.Connector
 		authKeyID uint16
 		password  string
+		poolSize  uint
 
-		creationWait sync.WaitGroup
-		destroyed    bool
-		keepAlive    *time.Timer
-		swapping     bool
+		destroyed bool
+		keepAlive *time.Timer
+		// inFlight counts the operations using a session of the pool
+		inFlight sync.WaitGroup
+		// users counts the operations using each session; sessions are only closed once they are no longer used
+		users map[*securechannel.SecureChannel]int
+		// retired contains the sessions that were replaced in the pool and are closed once their users are done
+		retired map[*securechannel.SecureChannel]bool
+		// background counts the swaps and closes of retired sessions that are running in the background
+		background sync.WaitGroup
+		// backgroundCtx is used for keepalive pings and swaps that are not triggered by a command; it is canceled
+		// by Destroy so that a stalled HSM doesn't block it
+		backgroundCtx    context.Context
+		cancelBackground context.CancelFunc
+		// pingInterval is the interval of the keepalive; 0 disables it
+		pingInterval time.Duration
+		// swapping contains the sessions that are currently being replaced
+		swapping map[*securechannel.SecureChannel]*swap
+		// pingPausedUntil suspends the keepalive while the device reboots after a reset
+		pingPausedUntil time.Time
+
+		// channelOptions are applied to every SecureChannel created by the manager
+		channelOptions []securechannel.Option
+		// deviceSerial is the serial number the device must have before a session is authenticated; 0 disables the check
+		deviceSerial uint32
+		// checkStatus enables the connector status check before the pool is created
+		checkStatus bool
+		// commandTimeout limits the duration of encrypted commands; 0 disables it
+		commandTimeout time.Duration
+	}
+
+	// Option configures optional parameters of a SessionManager
+	Option func(s *SessionManager)
+
+	// swap is a replacement of a session in progress
+	swap struct {
+		// done is closed once the swap has finished
+		done    chan struct{}
+		session *securechannel.SecureChannel
+		err     error
 	}
 )
 
 var (
-	echoPayload = []byte("keepalive")
+	// ErrNotImportedUnderWrap is returned if an object's origin does not indicate it was imported under wrap
+	ErrNotImportedUnderWrap = errors.New("object origin is not marked as imported under wrap")
+	// ErrDestroyed is returned if a command is sent using a SessionManager that has already been destroyed
+	ErrDestroyed = errors.New("sessionmanager has already been destroyed")
+	// ErrNoSession is returned if the pool of the SessionManager does not contain any session
+	ErrNoSession = errors.New("no session available")
+	// ErrDeviceSerialMismatch is returned if the device behind the connector does not have the serial number set
+	// using WithDeviceSerial
+	ErrDeviceSerialMismatch = errors.New("device serial number does not match")
+	// ErrInvalidResponseType is returned if the HSM responded with a different response than the command requires
+	ErrInvalidResponseType = securechannel.ErrInvalidResponseType
+	// ErrDeviceNotReady is returned if the connector reports that the HSM is absent or not ready
+	ErrDeviceNotReady = errors.New("HSM is not present or not ready")
+	// ErrObjectNotFound is returned if the object a command refers to does not exist on the HSM
+	ErrObjectNotFound = commands.ErrObjectNotFound
 )
 
 const (
-	pingInterval = 15 * time.Second
+	defaultPingInterval = 15 * time.Second
+	// pingNonceLength is the length of the random payload echoed by the keepalive
+	pingNonceLength = 16
+	// resetRebootTime is the time the device needs to reboot after a reset
+	resetRebootTime = 10 * time.Second
+
+	// MaxSessions is the number of sessions a YubiHSM2 can hold concurrently
+	MaxSessions = 16
 )
 
+// WithRequiredSecurityLevel sets the minimum security level the sessions of the manager must have reached before
+// encrypted commands are sent. See securechannel.WithRequiredSecurityLevel.
+func WithRequiredSecurityLevel(level securechannel.SecurityLevel) Option {
+	return func(s *SessionManager) {
+		s.channelOptions = append(s.channelOptions, securechannel.WithRequiredSecurityLevel(level))
+	}
+}
+
+// WithPingInterval sets the interval in which the sessions are pinged to keep them alive and to detect dead sessions.
+// It defaults to 15 seconds. An interval of 0 disables the keepalive.
+func WithPingInterval(interval time.Duration) Option {
+	return func(s *SessionManager) {
+		s.pingInterval = interval
+	}
+}
+
+// DisableKeepAlive disables the background keepalive, which is useful for short-lived processes. It is equivalent to
+// WithPingInterval(0). Dead sessions are then only detected when a command fails.
+func DisableKeepAlive() Option {
+	return WithPingInterval(0)
+}
+
+// WithMaxMessagesPerSession sets the number of messages after which a session has to be recreated. Sessions are
+// swapped in the background once 90% of the limit is used. It defaults to securechannel.MaxMessagesPerSession.
+func WithMaxMessagesPerSession(limit uint32) Option {
+	return func(s *SessionManager) {
+		s.channelOptions = append(s.channelOptions, securechannel.WithMaxMessages(limit))
+	}
+}
+
+// WithTimeout limits the duration of every encrypted command sent using the manager, in addition to the deadline of
+// the context passed to SendEncryptedCommandContext. The timeout covers the whole command: waiting for a session,
+// the round-trip and, if the session has to be swapped, authenticating its replacement and the retry. A command that
+// times out while in flight poisons its session, which is then swapped. The poisoned session can't be closed and keeps
+// its slot on the device until the device times it out, so frequent timeouts can exhaust the 16 session slots.
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *SessionManager) {
+		s.commandTimeout = timeout
+	}
+}
+
+// WithDeviceSerial pins the manager to the device with the given serial number. The serial is verified using
+// DeviceInfo before every session is authenticated, so a connector that routes to a different HSM is detected before
+// any credentials are used. The HTTP connector talks to a single device; to use a bank of HSMs run one connector per
+// device (e.g. using the --serial flag of yubihsm-connector) and pin each manager to its serial.
+func WithDeviceSerial(serial uint32) Option {
+	return func(s *SessionManager) {
+		s.deviceSerial = serial
+	}
+}
+
+// WithStatusCheck makes NewSessionManager request the connector status before the sessions are authenticated and fail
+// with ErrDeviceNotReady if the connector does not report the HSM as ready. This distinguishes a missing or locked
+// device from authentication errors. Connectors that don't support GetStatus, like the USB connector, fail the check.
+func WithStatusCheck() Option {
+	return func(s *SessionManager) {
+		s.checkStatus = true
+	}
+}
+
 // NewSessionManager creates a new instance of the SessionManager with poolSize connections.
-// Wait on channel Connected with a timeout to wait for active connections to be ready.
-func NewSessionManager(connector connector.Connector, authKeyID uint16, password string) (*SessionManager, error) {
+// Commands are distributed round-robin across the sessions of the pool.
+func NewSessionManager(connector connector.Connector, authKeyID uint16, password string, poolSize uint, options ...Option) (*SessionManager, error) {
+	if poolSize < 1 || poolSize > MaxSessions {
+		return nil, errors.New("invalid pool size")
+	}
+
 	manager := &SessionManager{
-		connector: connector,
-		authKeyID: authKeyID,
-		password:  password,
-		destroyed: false,
+		connector:    connector,
+		authKeyID:    authKeyID,
+		password:     password,
+		poolSize:     poolSize,
+		destroyed:    false,
+		lock:         make(chan struct{}, 1),
+		swapping:     make(map[*securechannel.SecureChannel]*swap),
+		users:        make(map[*securechannel.SecureChannel]int),
+		retired:      make(map[*securechannel.SecureChannel]bool),
+		pingInterval: defaultPingInterval,
 	}
 
-	err := manager.swapSession()
-	if err != nil {
-		return nil, err
+	for _, option := range options {
+		option(manager)
 	}
 
-	manager.keepAlive = time.NewTimer(pingInterval)
-	go manager.pingRoutine()
+	if manager.checkStatus {
+		err := manager.verifyConnectorStatus()
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	return manager, err
+	for i := uint(0); i < poolSize; i++ {
+		_, err := manager.swapSession(context.Background(), nil)
+		if err != nil {
+			for _, session := range manager.sessions {
+				session.Close()
+			}
+			return nil, err
+		}
+	}
+
+	manager.backgroundCtx, manager.cancelBackground = context.WithCancel(context.Background())
+	if manager.pingInterval > 0 {
+		manager.keepAlive = time.NewTimer(manager.pingInterval)
+		go manager.pingRoutine()
+	}
+
+	return manager, nil
 }
 
 func (s *SessionManager) pingRoutine() {
 	for range s.keepAlive.C {
-		command, _ := commands.CreateEchoCommand(echoPayload)
-
-		resp, err := s.SendEncryptedCommand(command)
-		if err == nil {
-			parsedResp, matched := resp.(*commands.EchoResponse)
-			if !matched {
-				err = errors.New("invalid response type")
+		s.acquire(context.Background())
+		if s.destroyed {
+			// The timer may have been reset by a ping that was running while the manager was destroyed
+			s.release()
+			return
+		}
+		paused := time.Now().Before(s.pingPausedUntil)
+		var sessions []*securechannel.SecureChannel
+		if !paused {
+			sessions = append(sessions, s.sessions...)
+			for _, session := range sessions {
+				s.holdSession(session)
 			}
-			if !bytes.Equal(parsedResp.Data, echoPayload) {
-				err = errors.New("echoed data is invalid")
+		}
+		s.release()
+		if paused {
+			s.keepAlive.Reset(s.pingInterval)
+			continue
+		}
+
+		for _, session := range sessions {
+			s.pingSession(session)
+			s.releaseSession(session)
+		}
+
+		s.keepAlive.Reset(s.pingInterval)
+	}
+}
+
+// pingSession sends an echo with a random nonce on session and swaps the session if it seems to be dead.
+// The nonce makes sure that a stale or replayed response is not mistaken for a healthy session.
+func (s *SessionManager) pingSession(session *securechannel.SecureChannel) {
+	nonce := make([]byte, pingNonceLength)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		log.Printf("generating keepalive nonce failed; err=%v", err)
+		return
+	}
+
+	data, err := echo(s.backgroundCtx, session, nonce)
+	if err == nil && !bytes.Equal(data, nonce) {
+		err = errors.New("echoed data is invalid")
+	}
+	if err == nil {
+		return
+	}
+
+	// Session seems to be dead - reconnect and swap
+	_, err = s.swapSession(s.backgroundCtx, session)
+	if err != nil {
+		log.Printf("swapping dead session failed; err=%v", err)
+	}
+}
+
+// swapSession replaces the session old in the pool with a newly authenticated session and returns it.
+// If old is nil, the new session is added to the pool. If old was already replaced, nil is returned. If old is being
+// replaced, swapSession waits for that swap to finish and returns its result; ctx only limits the time spent waiting. The old session is closed once it is
+// no longer used by any operation.
+func (s *SessionManager) swapSession(ctx context.Context, old *securechannel.SecureChannel) (*securechannel.SecureChannel, error) {
+	err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if s.destroyed {
+		s.release()
+		return nil, ErrDestroyed
+	}
+
+	var current *swap
+	if old != nil {
+		if !s.inPool(old) {
+			// old was already replaced; the caller can use any session of the pool
+			s.release()
+			return nil, nil
+		}
+		if pending, ok := s.swapping[old]; ok {
+			s.release()
+
+			select {
+			case <-pending.done:
+				if isContextError(pending.err) && ctx.Err() == nil {
+					// The swap was aborted by the deadline of the operation that started it; try again using ctx
+					return s.swapSession(ctx, old)
+				}
+				return pending.session, pending.err
+			case <-ctx.Done():
+				return nil, ctx.Err()
 			}
-		} else {
-			// Session seems to be dead - reconnect and swap
-			err = s.swapSession()
-			if err != nil {
-				log.Printf("swapping dead session failed; err=%v", err)
+		}
+		current = &swap{done: make(chan struct{})}
+		s.swapping[old] = current
+	}
+	s.background.Add(1)
+	s.release()
+	defer s.background.Done()
+
+	newSession, err := s.replaceSession(ctx, old)
+
+	if current != nil {
+		s.acquire(context.Background())
+		current.session, current.err = newSession, err
+		delete(s.swapping, old)
+		close(current.done)
+		s.release()
+	}
+
+	return newSession, err
+}
+
+// replaceSession authenticates a new session and puts it in the place of old in the pool.
+// The handshake is aborted once ctx is done.
+func (s *SessionManager) replaceSession(ctx context.Context, old *securechannel.SecureChannel) (*securechannel.SecureChannel, error) {
+	newSession, err := securechannel.NewSecureChannel(s.connector, s.authKeyID, s.password, s.channelOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.verifyDeviceSerial(ctx, newSession)
+	if err != nil {
+		return nil, err
+	}
+
+	err = newSession.AuthenticateContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.acquire(ctx)
+	if err != nil {
+		s.closeInBackground(newSession)
+		return nil, err
+	}
+	defer s.release()
+
+	if s.destroyed {
+		s.closeInBackground(newSession)
+		return nil, ErrDestroyed
+	}
+
+	if old == nil {
+		s.sessions = append(s.sessions, newSession)
+		return newSession, nil
+	}
+
+	for i, session := range s.sessions {
+		if session == old {
+			// Replace the session in the pool and close the old one once it is no longer used
+			s.sessions[i] = newSession
+			s.retired[old] = true
+			if s.users[old] == 0 {
+				delete(s.retired, old)
+				s.closeInBackground(old)
 			}
+			return newSession, nil
 		}
+	}
+
+	// The old session is no longer part of the pool
+	s.closeInBackground(newSession)
 
-		s.keepAlive.Reset(pingInterval)
+	return nil, ErrNoSession
+}
+
+// inPool reports whether session is part of the pool. The manager lock must be held.
+func (s *SessionManager) inPool(session *securechannel.SecureChannel) bool {
+	for _, pooled := range s.sessions {
+		if pooled == session {
+			return true
+		}
 	}
+
+	return false
+}
+
+// holdSession registers an operation using session. The manager lock must be held.
+func (s *SessionManager) holdSession(session *securechannel.SecureChannel) {
+	s.users[session]++
+	s.inFlight.Add(1)
 }
 
-func (s *SessionManager) swapSession() error {
-	// Lock swapping process
-	s.swapping = true
-	defer func() { s.swapping = false }()
+// releaseSession unregisters an operation using a session returned by nextSession or held using holdSession.
+// A session that was replaced in the meantime is closed once its last user is done.
+func (s *SessionManager) releaseSession(session *securechannel.SecureChannel) {
+	s.acquire(context.Background())
+	s.users[session]--
+	if s.users[session] <= 0 {
+		delete(s.users, session)
+		if s.retired[session] {
+			delete(s.retired, session)
+			s.closeInBackground(session)
+		}
+	}
+	s.release()
 
-	newSession, err := securechannel.NewSecureChannel(s.connector, s.authKeyID, s.password)
+	s.inFlight.Done()
+}
+
+// closeInBackground closes session without blocking; Destroy waits for it. The manager lock or a running swap must
+// be held, so that it can't race with Destroy.
+func (s *SessionManager) closeInBackground(session *securechannel.SecureChannel) {
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		session.Close()
+	}()
+}
+
+// verifyConnectorStatus checks that the connector reports the HSM as ready
+func (s *SessionManager) verifyConnectorStatus() error {
+	status, err := s.connector.GetStatus()
 	if err != nil {
-		return err
+		return fmt.Errorf("requesting connector status failed: %w", err)
+	}
+
+	if status.Status != connector.StatusOK {
+		return fmt.Errorf("%w: connector reports status %q", ErrDeviceNotReady, status.Status)
+	}
+
+	return nil
+}
+
+// verifyDeviceSerial checks that the device reached using session has the serial number set using WithDeviceSerial
+func (s *SessionManager) verifyDeviceSerial(ctx context.Context, session *securechannel.SecureChannel) error {
+	if s.deviceSerial == 0 {
+		return nil
 	}
 
-	err = newSession.Authenticate()
+	info, err := getDeviceInfo(ctx, session)
 	if err != nil {
 		return err
 	}
 
-	s.lock.Lock()
-	defer s.lock.Unlock()
-	// Close old session
-	if s.session != nil {
-		go s.session.Close()
+	if info.SerialNumber != s.deviceSerial {
+		return fmt.Errorf("%w: expected %d, got %d", ErrDeviceSerialMismatch, s.deviceSerial, info.SerialNumber)
 	}
 
-	// Replace primary session
-	s.session = newSession
-
 	return nil
 }
 
-func (s *SessionManager) checkSessionHealth() {
-	if s.session.Counter >= securechannel.MaxMessagesPerSession*0.9 && !s.swapping {
-		go s.swapSession()
+func (s *SessionManager) checkSessionHealth(session *securechannel.SecureChannel) {
+	// Swap the session once 90% of its messages are used or if an aborted command left it out of sync
+	if uint64(session.MessageCount())*10 >= uint64(session.MaxMessages())*9 || session.Poisoned() {
+		go s.swapSession(s.backgroundCtx, session)
+	}
+}
+
+// replacementSession returns replacement if it is still part of the pool and the next session of the pool otherwise.
+// replacement may be nil.
+// The caller must call releaseSession once it no longer uses the session.
+func (s *SessionManager) replacementSession(ctx context.Context, replacement *securechannel.SecureChannel) (*securechannel.SecureChannel, error) {
+	err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.destroyed && replacement != nil && s.inPool(replacement) {
+		s.holdSession(replacement)
+		s.release()
+		return replacement, nil
+	}
+	s.release()
+
+	return s.nextSession(ctx)
+}
+
+// nextSession returns the next session of the pool in round-robin order.
+// The caller must call releaseSession once it no longer uses the session.
+func (s *SessionManager) nextSession(ctx context.Context) (*securechannel.SecureChannel, error) {
+	err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer s.release()
+
+	if s.destroyed {
+		return nil, ErrDestroyed
+	}
+	if len(s.sessions) == 0 {
+		return nil, ErrNoSession
+	}
+
+	session := s.sessions[s.next%len(s.sessions)]
+	s.next = (s.next + 1) % len(s.sessions)
+	s.holdSession(session)
+
+	return session, nil
 }
 
 // SendEncryptedCommand sends an encrypted & authenticated command to the HSM
 // and returns the decrypted and parsed response.
 func (s *SessionManager) SendEncryptedCommand(c *commands.CommandMessage) (commands.Response, error) {
-	s.lock.Lock()
-	defer s.lock.Unlock()
+	return s.SendEncryptedCommandContext(context.Background(), c)
+}
 
-	// Check session health after executing the command
-	defer s.checkSessionHealth()
+// SendEncryptedCommandContext sends an encrypted & authenticated command to the HSM
+// and returns the decrypted and parsed response.
+// If the session has reached its message limit or is poisoned, it is swapped and the command is retried once.
+// The deadline of ctx is honored while waiting for the session, for the channel lock, during the HSM round-trip and
+// while the replacement session of a retry is authenticated.
+func (s *SessionManager) SendEncryptedCommandContext(ctx context.Context, c *commands.CommandMessage) (commands.Response, error) {
+	if s.commandTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.commandTimeout)
+		defer cancel()
+	}
 
-	if s.destroyed {
-		return nil, errors.New("sessionmanager has already been destroyed")
+	session, err := s.nextSession(ctx)
+	if err != nil {
+		return nil, err
 	}
-	if s.session == nil {
-		return nil, errors.New("no session available")
+
+	resp, err := session.SendEncryptedCommandContext(ctx, c)
+	if errors.Is(err, securechannel.ErrMessageLimit) || errors.Is(err, securechannel.ErrChannelPoisoned) {
+		// Both are checked before the command is sent, so it is safe to retry the command once on a fresh session.
+		// swapSession waits for a swap that was already started by another operation.
+		s.releaseSession(session)
+		var replacement *securechannel.SecureChannel
+		replacement, err = s.swapSession(ctx, session)
+		if err != nil {
+			return nil, err
+		}
+
+		session, err = s.replacementSession(ctx, replacement)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err = session.SendEncryptedCommandContext(ctx, c)
+	}
+	defer s.releaseSession(session)
+
+	// Check session health after executing the command
+	s.checkSessionHealth(session)
+
+	if err == nil && c.CommandType == commands.CommandTypeReset && s.acquire(ctx) == nil {
+		// The device reboots and the sessions are gone; don't ping until it is back up
+		s.pingPausedUntil = time.Now().Add(resetRebootTime)
+		s.release()
 	}
 
-	return s.session.SendEncryptedCommand(c)
+	return resp, err
 }
 
 // SendCommand sends an unauthenticated command to the HSM and returns the parsed response
 func (s *SessionManager) SendCommand(c *commands.CommandMessage) (commands.Response, error) {
-	s.lock.Lock()
-	defer s.lock.Unlock()
+	session, err := s.nextSession(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer s.releaseSession(session)
+
+	return session.SendCommand(c)
+}
+
+// Destroy closes all connections in the pool and wipes their session and authentication keys from memory.
+// It waits for commands that are in flight to finish; commands sent afterwards fail with ErrDestroyed.
+// Calling Destroy more than once is a no-op. SessionManager instances can't be reused.
+func (s *SessionManager) Destroy() {
+	for _, session := range s.shutdown() {
+		session.Close()
+	}
+}
+
+// Reset factory resets the HSM. All objects except the default authentication key are deleted and the device
+// reboots, so all sessions are gone. The keepalive is stopped and the manager is destroyed; create a new
+// SessionManager once the device is back up.
+func (s *SessionManager) Reset() error {
+	command, err := commands.CreateResetCommand()
+	if err != nil {
+		return err
+	}
 
+	_, err = s.SendEncryptedCommand(command)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range s.shutdown() {
+		// The sessions no longer exist on the device; Close only wipes the keys after the CloseSession failed
+		go session.Close()
+	}
+
+	return nil
+}
+
+// shutdown marks the manager as destroyed, stops the keepalive and waits for the commands in flight. It returns the
+// sessions of the pool, which the caller has to close, or nil if the manager was already destroyed.
+func (s *SessionManager) shutdown() []*securechannel.SecureChannel {
+	s.acquire(context.Background())
 	if s.destroyed {
-		return nil, errors.New("sessionmanager has already been destroyed")
+		s.release()
+		return nil
 	}
-	if s.session == nil {
-		return nil, errors.New("no session available")
+	s.destroyed = true
+	if s.keepAlive != nil {
+		s.keepAlive.Stop()
 	}
+	sessions := s.sessions
+	s.release()
+
+	// Abort pings and swaps that are running in the background
+	s.cancelBackground()
+	s.inFlight.Wait()
+	s.background.Wait()
 
-	return s.session.SendCommand(c)
+	return sessions
 }
 
-// Destroy closes all connections in the pool.
-// SessionManager instances can't be reused.
-func (s *SessionManager) Destroy() {
-	s.lock.Lock()
-	defer s.lock.Unlock()
+// GetOption returns the raw value of the device option, e.g. commands.OptionForceAudit
+func (s *SessionManager) GetOption(option uint8) ([]byte, error) {
+	parsedResp, err := s.getOption(option)
+	if err != nil {
+		return nil, err
+	}
 
-	s.keepAlive.Stop()
-	s.session.Close()
-	s.destroyed = true
+	return parsedResp.Data, nil
+}
+
+// GetCommandAudit returns the audit setting of every command as configured in the command audit option
+func (s *SessionManager) GetCommandAudit() (map[commands.CommandType]commands.AuditSetting, error) {
+	parsedResp, err := s.getOption(commands.OptionCommandAudit)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsedResp.CommandAudit()
+}
+
+// DeleteObject deletes the object id of type objType from the HSM. It returns ErrObjectNotFound if the object does
+// not exist, so that callers can treat an already deleted object as success.
+func (s *SessionManager) DeleteObject(id uint16, objType uint8) error {
+	command, err := commands.CreateDeleteObjectCommand(id, objType)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.SendEncryptedCommand(command)
+	if errors.Is(err, commands.ErrObjectNotFound) {
+		return ErrObjectNotFound
+	}
+
+	return err
+}
+
+// Blink makes the LED of the HSM blink for the given number of seconds, which helps to identify the device
+func (s *SessionManager) Blink(seconds uint8) error {
+	command, err := commands.CreateSetBlinkCommand(seconds)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.SendEncryptedCommand(command)
+	return err
+}
+
+// Echo sends data to the HSM over an authenticated session and returns the data echoed by the HSM
+func (s *SessionManager) Echo(data []byte) ([]byte, error) {
+	command, err := commands.CreateEchoCommand(data)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.SendEncryptedCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedResp, matched := resp.(*commands.EchoResponse)
+	if !matched {
+		return nil, ErrInvalidResponseType
+	}
+
+	return parsedResp.Data, nil
+}
+
+// GetDeviceInfo returns the version, serial number and supported algorithms of the HSM.
+// DeviceInfo does not require authentication, so it is sent as a plain command.
+func (s *SessionManager) GetDeviceInfo() (*commands.DeviceInfoResponse, error) {
+	session, err := s.nextSession(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer s.releaseSession(session)
+
+	return getDeviceInfo(context.Background(), session)
+}
+
+// GetObjectInfo returns the metadata of the object objID of type objType. Use commands.ObjectType and
+// commands.ObjectOrigin to format the type and origin of the object.
+func (s *SessionManager) GetObjectInfo(objID uint16, objType uint8) (*commands.ObjectInfoResponse, error) {
+	command, err := commands.CreateGetObjectInfoCommand(objID, objType)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.SendEncryptedCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedResp, matched := resp.(*commands.ObjectInfoResponse)
+	if !matched {
+		return nil, ErrInvalidResponseType
+	}
+
+	return parsedResp, nil
+}
+
+// GetDeviceAttestationCertificate returns the certificate of the device attestation key, which is stored as the
+// opaque object DeviceAttestationObjectID. The device attestation key signs the certificates requested using
+// CreateSignAttestationCertCommand with attestation key 0, so its certificate is the root of the chain on the device.
+func (s *SessionManager) GetDeviceAttestationCertificate() (*x509.Certificate, error) {
+	command, err := commands.CreateGetOpaqueCommand(commands.DeviceAttestationObjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.SendEncryptedCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedResp, matched := resp.(*commands.GetOpaqueResponse)
+	if !matched {
+		return nil, ErrInvalidResponseType
+	}
+
+	return x509.ParseCertificate(parsedResp.Data)
+}
+
+// VerifyImportedObject fetches the object info of an object and verifies that its origin is marked as imported
+// under wrap. Call this after ImportWrapped to confirm that a restored object was correctly marked by the HSM.
+func (s *SessionManager) VerifyImportedObject(objID uint16, objType uint8) error {
+	info, err := s.GetObjectInfo(objID, objType)
+	if err != nil {
+		return err
+	}
+
+	if !info.WasUnderWrap() {
+		return ErrNotImportedUnderWrap
+	}
+
+	return nil
+}
+
+// ObjectSequence returns the sequence number of an object.
+// The HSM increments the sequence of an object ID every time an object is stored under an ID that was previously
+// used by a deleted object. Record the sequence when provisioning a key and compare it before relying on cached data
+// keyed by the object ID; a changed sequence means the object was deleted and recreated in the meantime.
+func (s *SessionManager) ObjectSequence(objID uint16, objType uint8) (uint8, error) {
+	info, err := s.GetObjectInfo(objID, objType)
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Sequence, nil
+}
+
+// ListObjects lists the objects on the HSM that match all of the given options
+func (s *SessionManager) ListObjects(options ...commands.ListCommandOption) ([]commands.Object, error) {
+	command, err := commands.CreateListObjectsCommand(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.SendEncryptedCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedResp, matched := resp.(*commands.ListObjectsResponse)
+	if !matched {
+		return nil, ErrInvalidResponseType
+	}
+
+	return parsedResp.Objects, nil
+}
+
+// FindObjectsByLabel lists the objects on the HSM with the given label
+func (s *SessionManager) FindObjectsByLabel(label string) ([]commands.Object, error) {
+	labelOption, err := commands.NewLabelOption([]byte(label))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.ListObjects(labelOption)
+}
+
+// SessionSlotsInUse reports how many of the HSM's session slots are in use, including the ones held by the pool of this
+// manager. The HSM does not expose this number, so it is probed by authenticating additional sessions until the device
+// responds with ErrorCodeSessionFull or all slots not taken by the pool are occupied. This is useful to detect
+// sessions leaked by crashed clients.
+// All probe sessions are closed before returning, but while the probe is running the device may have no free slots
+// left: other clients may fail to open a session, and a session swap of this manager fails with ErrSessionFull. Call
+// it while the manager is idle.
+func (s *SessionManager) SessionSlotsInUse() (int, error) {
+	var probes []*securechannel.SecureChannel
+	defer func() {
+		for _, probe := range probes {
+			probe.Close()
+		}
+	}()
+
+	// The slots held by the pool can't be free, so probing them would only take slots needed by swaps
+	maxProbes := MaxSessions - int(s.poolSize)
+	for len(probes) < maxProbes {
+		probe, err := securechannel.NewSecureChannel(s.connector, s.authKeyID, s.password, s.channelOptions...)
+		if err != nil {
+			return 0, err
+		}
+
+		err = probe.Authenticate()
+		if errors.Is(err, commands.ErrSessionFull) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		probes = append(probes, probe)
+	}
+
+	return MaxSessions - len(probes), nil
+}
+
+// acquire locks the manager. It returns the context's error if ctx is done before the lock could be acquired.
+func (s *SessionManager) acquire(ctx context.Context) error {
+	select {
+	case s.lock <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release unlocks the manager
+func (s *SessionManager) release() {
+	<-s.lock
+}
+
+func (s *SessionManager) getOption(option uint8) (*commands.GetOptionResponse, error) {
+	command, err := commands.CreateGetOptionCommand(option)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.SendEncryptedCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedResp, matched := resp.(*commands.GetOptionResponse)
+	if !matched {
+		return nil, ErrInvalidResponseType
+	}
+
+	return parsedResp, nil
+}
+
+func getDeviceInfo(ctx context.Context, session *securechannel.SecureChannel) (*commands.DeviceInfoResponse, error) {
+	command, err := commands.CreateDeviceInfoCommand()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := session.SendCommandContext(ctx, command)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedResp, matched := resp.(*commands.DeviceInfoResponse)
+	if !matched {
+		return nil, ErrInvalidResponseType
+	}
+
+	return parsedResp, nil
+}
+
+func echo(ctx context.Context, session *securechannel.SecureChannel, data []byte) ([]byte, error) {
+	command, err := commands.CreateEchoCommand(data)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := session.SendEncryptedCommandContext(ctx, command)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedResp, matched := resp.(*commands.EchoResponse)
+	if !matched {
+		return nil, ErrInvalidResponseType
+	}
+
+	return parsedResp.Data, nil
+}
+
+// isContextError reports whether err was caused by a context that was canceled or whose deadline passed
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
 }
@@ -0,0 +1,163 @@
+package securechannel
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+
+	"github.com/certusone/yubihsm-go/commands"
+	"github.com/certusone/yubihsm-go/connector"
+	"github.com/enceve/crypto/cmac"
+)
+
+// asymmetricSharedInfo is the shared info of the X9.63 KDF used to derive the session keys: key usage, key type (AES)
+// and key length (16 bytes)
+var asymmetricSharedInfo = []byte{0x3c, 0x88, 0x10}
+
+// ErrAuthReceipt is returned if the receipt of an asymmetric authentication does not match, which means that the device
+// does not hold the private key of the expected device public key or that the host key is not registered on the HSM
+var ErrAuthReceipt = fmt.Errorf("%w: device sent wrong receipt", ErrAuthFailed)
+
+// GetDevicePublicKey requests the static public key of the device that is used for asymmetric authentication.
+// The key should be verified out of band before it is trusted.
+func GetDevicePublicKey(connector connector.Connector) (*ecdsa.PublicKey, error) {
+	command, err := commands.CreateGetDevicePublicKeyCommand()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := connector.Request(command)
+	if err != nil {
+		return nil, &ConnectorError{Err: err}
+	}
+
+	response, err := commands.ParseResponse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedResp, match := response.(*commands.GetDevicePublicKeyResponse)
+	if !match {
+		return nil, ErrInvalidResponseType
+	}
+
+	return parsedResp.PublicKey()
+}
+
+// authenticateAsymmetric establishes a session using the SCP11 key agreement. The session keys are derived from an
+// ephemeral and a static ECDH shared secret, and the receipt returned by the device proves that it derived the same
+// keys. The channel lock must be held.
+func (s *SecureChannel) authenticateAsymmetric(ctx context.Context) error {
+	curve := elliptic.P256()
+
+	ephemeralKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return err
+	}
+	hostPublicKey := elliptic.Marshal(curve, ephemeralKey.X, ephemeralKey.Y)
+
+	command, err := commands.CreateCreateSessionAsymmetricCommand(s.authKeySlot, hostPublicKey)
+	if err != nil {
+		return err
+	}
+	response, err := s.SendCommandContext(ctx, command)
+	if err != nil {
+		return err
+	}
+
+	createSessionResp, match := response.(*commands.CreateSessionAsymmetricResponse)
+	if !match {
+		return ErrInvalidResponseType
+	}
+
+	cardX, cardY := elliptic.Unmarshal(curve, createSessionResp.CardPublicKey)
+	if cardX == nil {
+		return fmt.Errorf("%w: invalid device ephemeral public key", ErrAuthFailed)
+	}
+
+	// Derive the receipt key and the session keys from the ephemeral-ephemeral and the static-static shared secrets
+	sharedSecret := append(ecdhSharedSecret(curve, ephemeralKey.D, cardX, cardY),
+		ecdhSharedSecret(curve, s.privateKey.D, s.devicePublicKey.X, s.devicePublicKey.Y)...)
+	defer zero(sharedSecret)
+
+	keys := x963KDF(sharedSecret, asymmetricSharedInfo, 4*KeyLength)
+	defer zero(keys)
+
+	receiptKey := keys[:KeyLength]
+	keyChain := &KeyChain{
+		EncKey:  append([]byte(nil), keys[KeyLength:2*KeyLength]...),
+		MACKey:  append([]byte(nil), keys[2*KeyLength:3*KeyLength]...),
+		RMACKey: append([]byte(nil), keys[3*KeyLength:]...),
+	}
+
+	// Verify the receipt over the ephemeral public keys
+	block, err := aes.NewCipher(receiptKey)
+	if err != nil {
+		return err
+	}
+	mac, err := cmac.New(block)
+	if err != nil {
+		return err
+	}
+	mac.Write(hostPublicKey)
+	mac.Write(createSessionResp.CardPublicKey)
+	receipt := mac.Sum([]byte{})
+
+	if subtle.ConstantTimeCompare(receipt, createSessionResp.Receipt) != 1 {
+		return ErrAuthReceipt
+	}
+
+	encBlock, err := aes.NewCipher(keyChain.EncKey)
+	if err != nil {
+		return err
+	}
+
+	s.ID = createSessionResp.SessionID
+	s.keyChain = keyChain
+	s.encBlock = encBlock
+	// The receipt is the initial value of the MAC chain
+	s.MACChainValue = receipt
+
+	// Set counter to 1 as specified by the protocol
+	atomic.StoreUint32(&s.Counter, 1)
+
+	s.SecurityLevel = SecurityLevelAuthenticated
+
+	return nil
+}
+
+// ecdhSharedSecret returns the x coordinate of d*(x, y) padded to the size of the curve
+func ecdhSharedSecret(curve elliptic.Curve, d *big.Int, x, y *big.Int) []byte {
+	sharedX, _ := curve.ScalarMult(x, y, d.Bytes())
+
+	b := sharedX.Bytes()
+	secret := make([]byte, (curve.Params().BitSize+7)/8)
+	copy(secret[len(secret)-len(b):], b)
+
+	return secret
+}
+
+// x963KDF derives length bytes from secret using the ANSI X9.63 KDF with SHA256
+func x963KDF(secret, sharedInfo []byte, length int) []byte {
+	var out []byte
+	counter := make([]byte, 4)
+	for i := uint32(1); len(out) < length; i++ {
+		binary.BigEndian.PutUint32(counter, i)
+
+		h := sha256.New()
+		h.Write(secret)
+		h.Write(counter)
+		h.Write(sharedInfo)
+		out = h.Sum(out)
+	}
+
+	return out[:length]
+}
@@ -2,12 +2,16 @@ package securechannel
 
 import (
 	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"encoding/binary"
 	"errors"
-	"sync"
+	"fmt"
+	"sync/atomic"
 
 	"github.com/enceve/crypto/cmac"
 	"github.com/certusone/yubihsm-go/authkey"
@@ -16,7 +20,12 @@ import (
 )
 
 type (
-	// SecureChannel implements a communication channel with a YubiHSM2 as specified in the SCP03 standard
+	// SecureChannel implements a communication channel with a YubiHSM2 as specified in the SCP03 standard.
+	//
+	// SCP03 chains the MAC of every message to the previous one and derives the IV from the message counter, so a
+	// channel can only have a single command in flight. Encrypted commands are serialized by the channelLock, which
+	// also guards all updates to Counter and MACChainValue. To execute commands concurrently, use multiple channels,
+	// e.g. by creating a SessionManager with a poolSize larger than 1.
 	SecureChannel struct {
 		// connector is used to communicate with the card
 		connector connector.Connector
@@ -24,15 +33,29 @@ type (
 		authKeySlot uint16
 		// keyChain holds the keys generated in the authentication ceremony
 		keyChain *KeyChain
-		// channelLock is used to lock encrypted communications to prevent race conditions
-		channelLock sync.Mutex
+		// encBlock is the AES cipher of keyChain.EncKey; it is created once per session to avoid the key schedule
+		// setup on every command
+		encBlock cipher.Block
+		// channelLock is used to lock encrypted communications to prevent race conditions.
+		// It is a semaphore rather than a sync.Mutex so that acquiring it can be aborted using a context.
+		channelLock chan struct{}
+		// buffer is reused to serialize and encrypt commands; it is guarded by the channelLock
+		buffer bytes.Buffer
 
 		// ID is the ID of the session with the HSM
 		ID uint8
-		// Counter of commands performed on the session
+		// Counter of commands performed on the session. It is written atomically; use MessageCount to read it while
+		// commands may be in flight.
 		Counter uint32
+		// maxMessages is the number of messages after which the session has to be recreated
+		maxMessages uint32
+		// poisoned is set to 1 if a command failed after the MAC chain was advanced, which leaves the session out of sync
+		// with the device. It is accessed atomically so that it can be read without the channelLock.
+		poisoned uint32
 		// SecurityLevel is the authentication state of the session
 		SecurityLevel SecurityLevel
+		// requiredSecurityLevel is the minimum SecurityLevel required to send encrypted commands
+		requiredSecurityLevel SecurityLevel
 
 		// HostChallenge is the auth challenge of the host
 		HostChallenge []byte
@@ -42,6 +65,11 @@ type (
 		// AuthKey to authenticate against the HSM; must match authKeySlot
 		AuthKey authkey.AuthKey
 
+		// privateKey is the static key of the host for asymmetric authentication; AuthKey is not used if it is set
+		privateKey *ecdsa.PrivateKey
+		// devicePublicKey is the static public key of the HSM for asymmetric authentication
+		devicePublicKey *ecdsa.PublicKey
+
 		// MACChainValue is the last MAC to allow MAC chaining
 		MACChainValue []byte
 	}
@@ -61,6 +89,27 @@ type (
 
 	// MessageType indicates whether a message is a command or response
 	MessageType byte
+
+	// Option configures optional parameters of a SecureChannel
+	Option func(s *SecureChannel)
+
+	// SecurityLevelError is returned if an encrypted command is sent on a channel below its required security level
+	SecurityLevelError struct {
+		Required SecurityLevel
+		Current  SecurityLevel
+	}
+
+	// ConnectorError wraps errors returned by the connector so that transport failures can be told apart from
+	// errors returned by the HSM. Use errors.As or errors.Unwrap to access the underlying error.
+	ConnectorError struct {
+		Err error
+	}
+
+	// AuthError is returned if authenticating the session failed because of an underlying error, e.g. a
+	// *ConnectorError or a *commands.Error. It matches ErrAuthFailed using errors.Is and unwraps to the cause.
+	AuthError struct {
+		Err error
+	}
 )
 
 const (
@@ -77,26 +126,119 @@ const (
 	DerivationConstantHostCryptogram   KeyDerivationConstant = 0x01
 
 	SecurityLevelUnauthenticated SecurityLevel = 0
-	SecurityLevelAuthenticated   SecurityLevel = 1
+	// SecurityLevelAuthenticated means that all messages are encrypted and MACed in both directions (C-MAC, C-DECRYPTION,
+	// R-MAC and R-ENCRYPTION as specified in SCP03)
+	SecurityLevelAuthenticated SecurityLevel = 1
 
 	MessageTypeCommand  MessageType = 0
 	MessageTypeResponse MessageType = 1
 
+	// MaxMessagesPerSession is the default number of messages after which a session has to be recreated
 	MaxMessagesPerSession = 10000
+
+	// sessionMessageOverhead is the length of the header, session ID and MAC of a SessionMessage
+	sessionMessageOverhead = 3 + 1 + MACLength
+)
+
+var (
+	// ErrAuthFailed is matched by all errors that indicate that the session could not be authenticated
+	ErrAuthFailed = errors.New("authentication failed")
+	// ErrAuthCryptogram is returned if the device cryptogram does not match, usually because of a wrong password
+	ErrAuthCryptogram = fmt.Errorf("%w: device sent wrong cryptogram", ErrAuthFailed)
+	// ErrInvalidResponseType is returned if the HSM responded with a different response than the command requires
+	ErrInvalidResponseType = errors.New("invalid response type")
+	// ErrMACMismatch is returned if the MAC of a response does not match; the session should be recreated
+	ErrMACMismatch = errors.New("invalid response MAC")
+	// ErrSessionIDMismatch is returned if the HSM responded with a session message for a different session
+	ErrSessionIDMismatch = errors.New("response session ID does not match the session")
+	// ErrMessageLimit is returned if the channel has sent its maximum number of messages (see WithMaxMessages)
+	ErrMessageLimit = errors.New("channel has reached its message limit; please recreate")
+	// ErrChannelPoisoned is returned if an earlier command failed after it was sent and the session state may be out
+	// of sync with the device
+	ErrChannelPoisoned = errors.New("channel was poisoned by an aborted command; please recreate")
 )
 
-var ErrAuthCryptogram = errors.New("authentication failed: device sent wrong cryptogram")
+// WithRequiredSecurityLevel sets the minimum security level the channel must have reached before encrypted commands are
+// sent. Encrypted commands always require an authenticated session, so levels below SecurityLevelAuthenticated
+// (the default) are ignored.
+func WithRequiredSecurityLevel(level SecurityLevel) Option {
+	return func(s *SecureChannel) {
+		if level > s.requiredSecurityLevel {
+			s.requiredSecurityLevel = level
+		}
+	}
+}
+
+// WithMaxMessages sets the number of messages after which the channel refuses to send commands and has to be
+// recreated. It defaults to MaxMessagesPerSession; 0 keeps the default.
+func WithMaxMessages(limit uint32) Option {
+	return func(s *SecureChannel) {
+		if limit > 0 {
+			s.maxMessages = limit
+		}
+	}
+}
 
 // NewSecureChannel initiates a new secure channel to communicate with an HSM using the given authKey
 // Call Authenticate next to establish a session.
-func NewSecureChannel(connector connector.Connector, authKeySlot uint16, password string) (*SecureChannel, error) {
+func NewSecureChannel(connector connector.Connector, authKeySlot uint16, password string, options ...Option) (*SecureChannel, error) {
+	return newSecureChannel(connector, authKeySlot, authkey.NewFromPassword(password), options...)
+}
+
+// NewSecureChannelWithKeys initiates a new secure channel to communicate with an HSM using an authKey that consists of
+// raw encryption and MAC keys instead of being derived from a password.
+// Call Authenticate next to establish a session.
+func NewSecureChannelWithKeys(connector connector.Connector, authKeySlot uint16, encKey, macKey []byte, options ...Option) (*SecureChannel, error) {
+	authKey, err := authkey.NewFromKeys(encKey, macKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSecureChannel(connector, authKeySlot, authKey, options...)
+}
+
+// NewSecureChannelAsymmetric initiates a new secure channel to communicate with an HSM using an asymmetric
+// authentication key (SCP11). privateKey is the P256 key whose public key is stored in authKeySlot and
+// devicePublicKey is the static public key of the HSM as returned by GetDevicePublicKey. The device public key
+// should be verified out of band, since it is what authenticates the HSM to the host.
+// privateKey is owned by the caller: the channel drops its reference on Close but does not wipe it.
+// Call Authenticate next to establish a session.
+func NewSecureChannelAsymmetric(connector connector.Connector, authKeySlot uint16, privateKey *ecdsa.PrivateKey, devicePublicKey *ecdsa.PublicKey, options ...Option) (*SecureChannel, error) {
+	if privateKey == nil || devicePublicKey == nil {
+		return nil, errors.New("asymmetric authentication requires a private key and a device public key")
+	}
+	if privateKey.Curve != elliptic.P256() || devicePublicKey.Curve != elliptic.P256() {
+		return nil, errors.New("asymmetric authentication requires P256 keys")
+	}
+	if devicePublicKey.X == nil || devicePublicKey.Y == nil || !elliptic.P256().IsOnCurve(devicePublicKey.X, devicePublicKey.Y) {
+		return nil, errors.New("device public key is not a point on the P256 curve")
+	}
+
+	channel, err := newSecureChannel(connector, authKeySlot, nil, options...)
+	if err != nil {
+		return nil, err
+	}
+	channel.privateKey = privateKey
+	channel.devicePublicKey = devicePublicKey
+
+	return channel, nil
+}
+
+func newSecureChannel(connector connector.Connector, authKeySlot uint16, authKey authkey.AuthKey, options ...Option) (*SecureChannel, error) {
 	channel := &SecureChannel{
-		ID:            0,
-		AuthKey:       authkey.NewFromPassword(password),
-		MACChainValue: make([]byte, 16),
-		SecurityLevel: SecurityLevelUnauthenticated,
-		authKeySlot:   authKeySlot,
-		connector:     connector,
+		ID:                    0,
+		AuthKey:               authKey,
+		MACChainValue:         make([]byte, 16),
+		SecurityLevel:         SecurityLevelUnauthenticated,
+		requiredSecurityLevel: SecurityLevelAuthenticated,
+		maxMessages:           MaxMessagesPerSession,
+		authKeySlot:           authKeySlot,
+		connector:             connector,
+		channelLock:           make(chan struct{}, 1),
+	}
+
+	for _, option := range options {
+		option(channel)
 	}
 
 	hostChallenge := make([]byte, 8)
@@ -111,22 +253,35 @@ func NewSecureChannel(connector connector.Connector, authKeySlot uint16, passwor
 
 // Authenticate establishes an authenticated session with the HSM
 func (s *SecureChannel) Authenticate() error {
+	return s.AuthenticateContext(context.Background())
+}
+
+// AuthenticateContext establishes an authenticated session with the HSM.
+// The handshake is aborted once ctx is done; the channel has to be recreated in that case.
+func (s *SecureChannel) AuthenticateContext(ctx context.Context) error {
+	err := s.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.unlock()
+
 	if s.SecurityLevel != SecurityLevelUnauthenticated {
 		return errors.New("the session is already authenticated")
 	}
 
-	s.channelLock.Lock()
-	defer s.channelLock.Unlock()
+	if s.privateKey != nil {
+		return s.authenticateAsymmetric(ctx)
+	}
 
 	command, _ := commands.CreateCreateSessionCommand(s.authKeySlot, s.HostChallenge)
-	response, err := s.SendCommand(command)
+	response, err := s.SendCommandContext(ctx, command)
 	if err != nil {
 		return err
 	}
 
 	createSessionResp, match := response.(*commands.CreateSessionResponse)
 	if !match {
-		return errors.New("invalid response type")
+		return ErrInvalidResponseType
 	}
 
 	s.ID = createSessionResp.SessionID
@@ -159,13 +314,13 @@ func (s *SecureChannel) Authenticate() error {
 	if err != nil {
 		return err
 	}
-	_, err = s.sendMACCommand(authenticateCommand)
+	_, err = s.sendMACCommand(ctx, authenticateCommand)
 	if err != nil {
-		return err
+		return &AuthError{Err: err}
 	}
 
 	// Set counter to 1 as specified by the protocol
-	s.Counter = 1
+	atomic.StoreUint32(&s.Counter, 1)
 
 	s.SecurityLevel = SecurityLevelAuthenticated
 
@@ -174,9 +329,15 @@ func (s *SecureChannel) Authenticate() error {
 
 // SendCommand sends an unauthenticated command to the HSM and returns the parsed response
 func (s *SecureChannel) SendCommand(c *commands.CommandMessage) (commands.Response, error) {
-	resp, err := s.connector.Request(c)
+	return s.SendCommandContext(context.Background(), c)
+}
+
+// SendCommandContext sends an unauthenticated command to the HSM and returns the parsed response.
+// The request is aborted once ctx is done.
+func (s *SecureChannel) SendCommandContext(ctx context.Context, c *commands.CommandMessage) (commands.Response, error) {
+	resp, err := s.connector.RequestContext(ctx, c)
 	if err != nil {
-		return nil, err
+		return nil, &ConnectorError{Err: err}
 	}
 
 	return commands.ParseResponse(resp)
@@ -185,24 +346,48 @@ func (s *SecureChannel) SendCommand(c *commands.CommandMessage) (commands.Respon
 // SendEncryptedCommand sends an encrypted & authenticated command to the HSM
 // and returns the decrypted and parsed response.
 func (s *SecureChannel) SendEncryptedCommand(c *commands.CommandMessage) (commands.Response, error) {
-	if s.SecurityLevel != SecurityLevelAuthenticated {
-		return nil, errors.New("the session is not authenticated")
+	return s.SendEncryptedCommandContext(context.Background(), c)
+}
+
+// SendEncryptedCommandContext sends an encrypted & authenticated command to the HSM
+// and returns the decrypted and parsed response.
+// ctx is honored while waiting for the channel lock and during the round-trip to the HSM. If ctx is done before
+// the command was sent, the session state is left untouched. If it is done while the command is in flight, the
+// device may or may not have processed it; the channel is poisoned and has to be recreated. The same applies to any
+// other failure after the command was sent, e.g. a connector error or an invalid response MAC.
+func (s *SecureChannel) SendEncryptedCommandContext(ctx context.Context, c *commands.CommandMessage) (commands.Response, error) {
+	// Lock the encrypted channel
+	err := s.lock(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer s.unlock()
 
-	if s.Counter >= MaxMessagesPerSession {
-		return nil, errors.New("channel has reached its message limit; please recreate")
+	// Don't touch the session state if the context expired while waiting for the lock
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
 	}
 
-	// Lock the encrypted channel
-	s.channelLock.Lock()
-	defer s.channelLock.Unlock()
+	// The session state is checked under the lock since Close wipes it concurrently
+	if s.SecurityLevel < s.requiredSecurityLevel {
+		return nil, &SecurityLevelError{Required: s.requiredSecurityLevel, Current: s.SecurityLevel}
+	}
+	if s.encBlock == nil {
+		return nil, &SecurityLevelError{Required: s.requiredSecurityLevel, Current: SecurityLevelUnauthenticated}
+	}
 
-	// Create the cipher using the session encryption key
-	block, err := aes.NewCipher(s.keyChain.EncKey)
-	if err != nil {
-		return nil, err
+	if s.Poisoned() {
+		return nil, ErrChannelPoisoned
+	}
+
+	// The counter is checked under the lock since concurrent commands advance it. Closing the session is always
+	// allowed, so that exhausted sessions don't stay open on the device.
+	if s.Counter >= s.maxMessages && c.CommandType != commands.CommandTypeCloseSession {
+		return nil, ErrMessageLimit
 	}
 
+	block := s.encBlock
+
 	// Pad the counter by 12 bytes
 	icv := new(bytes.Buffer)
 	icv.Write(bytes.Repeat([]byte{0}, 12))
@@ -215,24 +400,48 @@ func (s *SecureChannel) SendEncryptedCommand(c *commands.CommandMessage) (comman
 	// Setup the CBC encrypter
 	encrypter := cipher.NewCBCEncrypter(block, iv)
 
-	// Serialize and encrypt the wrapped command
-	commandData, _ := c.Serialize()
-	encryptedCommand := make([]byte, len(pad(commandData)))
-	encrypter.CryptBlocks(encryptedCommand, pad(commandData))
+	// Serialize the wrapped command into the reused buffer and encrypt it in place
+	s.buffer.Reset()
+	err = c.SerializeTo(&s.buffer)
+	if err != nil {
+		zero(s.buffer.Bytes())
+		return nil, err
+	}
+	padBuffer(&s.buffer)
+	encryptedCommand := s.buffer.Bytes()
+
+	// The wrapped command has to fit into a SessionMessage; check before the MAC chain is advanced
+	if length := sessionMessageOverhead + len(encryptedCommand); length > commands.MaxMessageSize {
+		zero(encryptedCommand)
+		return nil, fmt.Errorf("encrypted command is %d bytes long and exceeds the maximum message size of %d bytes", length, commands.MaxMessageSize)
+	}
+
+	encrypter.CryptBlocks(encryptedCommand, encryptedCommand)
+
+	// The MAC chain is advanced when the command is sent. If anything fails until the response MAC is verified, it
+	// is unknown whether the device processed the command and the session is out of sync.
+	poison := func(err error) (commands.Response, error) {
+		atomic.StoreUint32(&s.poisoned, 1)
+		return nil, err
+	}
 
 	// Send the wrapped command in a SessionMessage
-	resp, err := s.sendMACCommand(&commands.CommandMessage{
+	resp, err := s.sendMACCommand(ctx, &commands.CommandMessage{
 		CommandType: commands.CommandTypeSessionMessage,
 		Data:        encryptedCommand,
 	})
 	if err != nil {
-		return nil, err
+		return poison(err)
 	}
 
 	// Cast and check the response
 	sessionMessage, match := resp.(*commands.SessionMessageResponse)
 	if !match {
-		return nil, errors.New("invalid response type")
+		return poison(ErrInvalidResponseType)
+	}
+
+	if sessionMessage.SessionID != s.ID {
+		return poison(ErrSessionIDMismatch)
 	}
 
 	// Verify MAC
@@ -241,13 +450,16 @@ func (s *SecureChannel) SendEncryptedCommand(c *commands.CommandMessage) (comman
 		SessionID:   &sessionMessage.SessionID,
 		Data:        sessionMessage.EncryptedData,
 	}, MessageTypeResponse)
+	if err != nil {
+		return poison(err)
+	}
 
 	if !bytes.Equal(expectedMac[:MACLength], sessionMessage.MAC) {
-		return nil, errors.New("invalid response MAC")
+		return poison(ErrMACMismatch)
 	}
 
 	// Update session state
-	s.Counter++
+	atomic.AddUint32(&s.Counter, 1)
 
 	// Init the CBC decrypter
 	decrypter := cipher.NewCBCDecrypter(block, iv)
@@ -257,9 +469,41 @@ func (s *SecureChannel) SendEncryptedCommand(c *commands.CommandMessage) (comman
 	decrypter.CryptBlocks(decryptedResponse, sessionMessage.EncryptedData)
 
 	// Parse and return the wrapped response
-	return commands.ParseResponse(unpad(decryptedResponse))
+	response, err := commands.ParseResponse(unpad(decryptedResponse))
+	if err != nil {
+		return nil, err
+	}
+
+	// A reset closes all sessions and reboots the device
+	if c.CommandType == commands.CommandTypeReset {
+		s.SecurityLevel = SecurityLevelUnauthenticated
+	}
+
+	return response, nil
+}
+
+// Poisoned returns whether a command failed after it was sent, leaving the session out of sync with the device. A
+// poisoned channel refuses to send further encrypted commands and has to be recreated. It does not wait for the
+// command in flight.
+func (s *SecureChannel) Poisoned() bool {
+	return atomic.LoadUint32(&s.poisoned) == 1
+}
+
+// MessageCount returns the number of messages sent on the session. It does not wait for the command in flight.
+func (s *SecureChannel) MessageCount() uint32 {
+	return atomic.LoadUint32(&s.Counter)
+}
+
+// MaxMessages returns the number of messages after which the channel has to be recreated
+func (s *SecureChannel) MaxMessages() uint32 {
+	return s.maxMessages
 }
 
+// Close closes the session on the HSM and wipes the session and symmetric authentication keys from memory.
+// The keys are wiped even if the session could not be closed on the HSM. The private key of an asymmetric channel is
+// owned by the caller and is not wiped.
+// A poisoned channel can't send the close command, so Close fails with ErrChannelPoisoned and the session stays open
+// on the device, occupying one of its session slots, until the device times it out after 30 seconds of inactivity.
 func (s *SecureChannel) Close() error {
 	command, err := commands.CreateCloseSessionCommand()
 	if err != nil {
@@ -267,15 +511,81 @@ func (s *SecureChannel) Close() error {
 	}
 
 	_, err = s.SendEncryptedCommand(command)
-	if err != nil {
-		return err
+
+	s.lock(context.Background())
+	s.zeroize()
+	s.unlock()
+
+	return err
+}
+
+// zeroize overwrites the session and symmetric authentication keys with zeros and marks the channel as
+// unauthenticated. The reference to the private key of an asymmetric channel is dropped, but the key itself belongs to
+// the caller and is not overwritten.
+func (s *SecureChannel) zeroize() {
+	if s.keyChain != nil {
+		zero(s.keyChain.EncKey)
+		zero(s.keyChain.MACKey)
+		zero(s.keyChain.RMACKey)
 	}
+	zero(s.AuthKey)
+	s.encBlock = nil
+	s.privateKey = nil
 
-	return nil
+	s.SecurityLevel = SecurityLevelUnauthenticated
+}
+
+// Error formats the SecurityLevelError into a human readable format
+func (e *SecurityLevelError) Error() string {
+	if e.Current == SecurityLevelUnauthenticated {
+		return "the session is not authenticated"
+	}
+
+	return fmt.Sprintf("the session security level %d is below the required level %d", e.Current, e.Required)
+}
+
+// Error formats the ConnectorError into a human readable format
+func (e *ConnectorError) Error() string {
+	return "connector request failed: " + e.Err.Error()
+}
+
+// Unwrap returns the error returned by the connector
+func (e *ConnectorError) Unwrap() error {
+	return e.Err
+}
+
+// Error formats the AuthError into a human readable format
+func (e *AuthError) Error() string {
+	return ErrAuthFailed.Error() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the cause of the failed authentication
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrAuthFailed
+func (e *AuthError) Is(target error) bool {
+	return target == ErrAuthFailed
+}
+
+// lock acquires the channelLock. It returns the context's error if ctx is done before the lock could be acquired.
+func (s *SecureChannel) lock(ctx context.Context) error {
+	select {
+	case s.channelLock <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// unlock releases the channelLock
+func (s *SecureChannel) unlock() {
+	<-s.channelLock
 }
 
 // sendMACCommand sends a MAC authenticated command to the HSM and returns a parsed response
-func (s *SecureChannel) sendMACCommand(c *commands.CommandMessage) (commands.Response, error) {
+func (s *SecureChannel) sendMACCommand(ctx context.Context, c *commands.CommandMessage) (commands.Response, error) {
 
 	// Set command sessionID to this session
 	c.SessionID = &s.ID
@@ -292,7 +602,7 @@ func (s *SecureChannel) sendMACCommand(c *commands.CommandMessage) (commands.Res
 	// Set command MAC to calculated mac
 	c.MAC = sum[:MACLength]
 
-	return s.SendCommand(c)
+	return s.SendCommandContext(ctx, c)
 }
 
 // calculateMAC calculates the authenticated MAC for a command or response.
@@ -354,6 +664,11 @@ func (s *SecureChannel) updateKeychain() error {
 	}
 	keyChain.EncKey = encKey
 
+	encBlock, err := aes.NewCipher(encKey)
+	if err != nil {
+		return err
+	}
+
 	macKey, err := s.deriveKDF(s.AuthKey.GetMacKey(), DerivationConstantMACKey, KeyLength)
 	if err != nil {
 		return err
@@ -367,6 +682,7 @@ func (s *SecureChannel) updateKeychain() error {
 	keyChain.RMACKey = rmacKey
 
 	s.keyChain = keyChain
+	s.encBlock = encBlock
 	return nil
 }
 
@@ -5,36 +5,33 @@ import (
 	"crypto/aes"
 )
 
-// pad adds a padding to src until using the mechanism specified in SCP03 until it has a len that is a multiple of
-// aes.BlockSize and returns the result
-func pad(src []byte) []byte {
-	if aes.BlockSize-len(src)%aes.BlockSize == 0 {
-		return src
+// padBuffer adds a padding to the contents of buffer using the mechanism specified in SCP03 until it has a len that
+// is a multiple of aes.BlockSize. The 0x80 marker is mandatory, so block aligned input gets a full block of padding.
+func padBuffer(buffer *bytes.Buffer) {
+	buffer.WriteByte(0x80)
+	for buffer.Len()%aes.BlockSize != 0 {
+		buffer.WriteByte(0x00)
 	}
-
-	padding := aes.BlockSize - len(src)%aes.BlockSize - 1
-	padtext := bytes.Repeat([]byte{0}, padding)
-	padtext = append([]byte{0x80}, padtext...)
-	return append(src, padtext...)
 }
 
-// unpad removes the padding from src using the mechanism specified in SCP03 and returns the result
+// unpad removes the padding from src using the mechanism specified in SCP03 and returns the result. src is returned
+// unchanged if it does not end with a 0x80 marker followed by zeros.
 func unpad(src []byte) []byte {
-	if src[len(src)-1] != 0x00 && src[len(src)-1] != 0x80 {
-		return src
+	i := len(src) - 1
+	for i >= 0 && src[i] == 0x00 {
+		i--
 	}
 
-	padLen := 0
-	for i := len(src) - 1; i >= 0; i-- {
-		if src[i] == 0x00 {
-			padLen++
-			continue
-		}
-		if src[i] == 0x80 {
-			padLen++
-			break
-		}
+	if i < 0 || src[i] != 0x80 {
+		return src
 	}
 
-	return src[:len(src)-padLen]
+	return src[:i]
+}
+
+// zero overwrites b with zeros
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
 }
@@ -0,0 +1,102 @@
+package securechannel
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPadBuffer(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		expected []byte
+	}{
+		{
+			name:     "empty",
+			input:    nil,
+			expected: append([]byte{0x80}, make([]byte, 15)...),
+		},
+		{
+			name:     "partial block",
+			input:    []byte{0x01, 0x02, 0x03},
+			expected: append([]byte{0x01, 0x02, 0x03, 0x80}, make([]byte, 12)...),
+		},
+		{
+			name:     "one byte short of a block",
+			input:    bytes.Repeat([]byte{0x01}, 15),
+			expected: append(bytes.Repeat([]byte{0x01}, 15), 0x80),
+		},
+		{
+			name:     "full block",
+			input:    bytes.Repeat([]byte{0x01}, 16),
+			expected: append(append(bytes.Repeat([]byte{0x01}, 16), 0x80), make([]byte, 15)...),
+		},
+	}
+
+	for _, test := range tests {
+		buffer := bytes.NewBuffer(append([]byte(nil), test.input...))
+		padBuffer(buffer)
+		if !bytes.Equal(buffer.Bytes(), test.expected) {
+			t.Errorf("%s: padded to %x, expected %x", test.name, buffer.Bytes(), test.expected)
+		}
+
+		if unpadded := unpad(buffer.Bytes()); !bytes.Equal(unpadded, test.input) {
+			t.Errorf("%s: unpadded to %x, expected %x", test.name, unpadded, test.input)
+		}
+	}
+}
+
+func TestUnpad(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		expected []byte
+	}{
+		{
+			name:     "empty",
+			input:    []byte{},
+			expected: []byte{},
+		},
+		{
+			name:     "marker only",
+			input:    []byte{0x80},
+			expected: []byte{},
+		},
+		{
+			name:     "marker and zeros",
+			input:    []byte{0x01, 0x80, 0x00, 0x00},
+			expected: []byte{0x01},
+		},
+		{
+			name:     "data ending with 0x80",
+			input:    []byte{0x80, 0x80, 0x00},
+			expected: []byte{0x80},
+		},
+		{
+			name:     "no padding",
+			input:    []byte{0x01, 0x02},
+			expected: []byte{0x01, 0x02},
+		},
+		{
+			name:     "missing marker",
+			input:    []byte{0x01, 0x02, 0x00, 0x00},
+			expected: []byte{0x01, 0x02, 0x00, 0x00},
+		},
+		{
+			name:     "marker before data",
+			input:    []byte{0x80, 0x01, 0x00},
+			expected: []byte{0x80, 0x01, 0x00},
+		},
+		{
+			name:     "all zeros",
+			input:    make([]byte, 16),
+			expected: make([]byte, 16),
+		},
+	}
+
+	for _, test := range tests {
+		if unpadded := unpad(test.input); !bytes.Equal(unpadded, test.expected) {
+			t.Errorf("%s: unpadded to %x, expected %x", test.name, unpadded, test.expected)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+//go:build go1.20
+// +build go1.20
+
+package signer
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"errors"
+)
+
+// checkEd25519Options rejects the Ed25519ph and Ed25519ctx variants, which the HSM doesn't support. Signing them as
+// plain Ed25519 would return a signature for a different scheme than the caller asked for.
+func checkEd25519Options(opts crypto.SignerOpts) error {
+	options, ok := opts.(*ed25519.Options)
+	if !ok {
+		return nil
+	}
+
+	if options.Hash != crypto.Hash(0) {
+		return errors.New("ed25519ph is not supported")
+	}
+	if options.Context != "" {
+		return errors.New("ed25519ctx is not supported")
+	}
+
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !go1.20
+// +build !go1.20
+
+package signer
+
+import "crypto"
+
+// checkEd25519Options is a no-op before Go 1.20, which added ed25519.Options and the Ed25519ph and Ed25519ctx
+// variants
+func checkEd25519Options(opts crypto.SignerOpts) error {
+	return nil
+}
@@ -0,0 +1,160 @@
+package signer
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/certusone/yubihsm-go"
+	"github.com/certusone/yubihsm-go/commands"
+)
+
+type (
+	// HSMSigner implements crypto.Signer for an asymmetric key stored on a YubiHSM2
+	HSMSigner struct {
+		manager   *yubihsm.SessionManager
+		keyID     uint16
+		algorithm commands.Algorithm
+
+		// publicKey is the public key of the HSM key; it is fetched when the signer is created
+		publicKey crypto.PublicKey
+	}
+)
+
+// ErrUnsupportedAlgorithm is returned by NewHSMSigner for key algorithms whose public key can't be represented by
+// the crypto packages of the standard library, e.g. secp256k1 and Brainpool curves
+var ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+
+// NewHSMSigner creates a new instance of HSMSigner for the key keyID using algorithm. Ed25519, P224, P256, P384 and
+// P521 keys are supported. The public key is fetched from the HSM, so the key must exist and match algorithm.
+func NewHSMSigner(manager *yubihsm.SessionManager, keyID uint16, algorithm commands.Algorithm) (*HSMSigner, error) {
+	switch algorithm {
+	case commands.AlgorithmED25519,
+		commands.AlgorithmP224, commands.AlgorithmP256, commands.AlgorithmP384, commands.AlgorithmP521:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algorithm)
+	}
+
+	signer := &HSMSigner{
+		manager:   manager,
+		keyID:     keyID,
+		algorithm: algorithm,
+	}
+
+	publicKey, err := signer.fetchPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	signer.publicKey = publicKey
+
+	return signer, nil
+}
+
+// Public returns the public key of the HSM key
+func (s *HSMSigner) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// fetchPublicKey requests the public key of the HSM key and checks that it matches the signer algorithm
+func (s *HSMSigner) fetchPublicKey() (crypto.PublicKey, error) {
+	command, err := commands.CreateGetPubKeyCommand(s.keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.manager.SendEncryptedCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedResp, matched := resp.(*commands.GetPubKeyResponse)
+	if !matched {
+		return nil, yubihsm.ErrInvalidResponseType
+	}
+
+	if parsedResp.Algorithm != s.algorithm {
+		return nil, errors.New("the key algorithm does not match the signer algorithm")
+	}
+
+	if parsedResp.Algorithm == commands.AlgorithmED25519 {
+		return parsedResp.Ed25519()
+	}
+
+	return parsedResp.ECDSA()
+}
+
+// Sign signs digest with the HSM key.
+// Ed25519 signs the message itself instead of a digest, so digest must be the full message and opts.HashFunc() must
+// return crypto.Hash(0). The Ed25519ph and Ed25519ctx variants selected using *ed25519.Options are not supported.
+// ECDSA signatures are returned in the ASN.1 DER encoding expected by crypto/x509 and crypto/tls.
+func (s *HSMSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	switch s.algorithm {
+	case commands.AlgorithmED25519:
+		return s.signEddsa(digest, opts)
+	case commands.AlgorithmP224, commands.AlgorithmP256, commands.AlgorithmP384, commands.AlgorithmP521:
+		return s.signEcdsa(digest)
+	default:
+		return nil, errors.New("unsupported algorithm")
+	}
+}
+
+func (s *HSMSigner) signEddsa(message []byte, opts crypto.SignerOpts) ([]byte, error) {
+	err := checkEd25519Options(opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.HashFunc() != crypto.Hash(0) {
+		return nil, errors.New("ed25519 can't sign pre-hashed messages")
+	}
+
+	command, err := commands.CreateSignDataEddsaCommand(s.keyID, message)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.manager.SendEncryptedCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedResp, matched := resp.(*commands.SignDataEddsaResponse)
+	if !matched {
+		return nil, yubihsm.ErrInvalidResponseType
+	}
+
+	if len(parsedResp.Signature) != ed25519.SignatureSize {
+		return nil, errors.New("invalid ed25519 signature length")
+	}
+
+	return parsedResp.Signature, nil
+}
+
+// signEcdsa signs a digest using ECDSA. The HSM already returns the signature ASN.1 DER encoded.
+func (s *HSMSigner) signEcdsa(digest []byte) ([]byte, error) {
+	expected, err := commands.ECDSADigestLength(s.algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if len(digest) != expected {
+		return nil, fmt.Errorf("invalid digest length %d for %s; expected %d", len(digest), s.algorithm, expected)
+	}
+
+	command, err := commands.CreateSignDataEcdsaCommand(s.keyID, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.manager.SendEncryptedCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedResp, matched := resp.(*commands.SignDataEcdsaResponse)
+	if !matched {
+		return nil, yubihsm.ErrInvalidResponseType
+	}
+
+	return parsedResp.Signature, nil
+}
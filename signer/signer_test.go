@@ -0,0 +1,66 @@
+//go:build go1.20
+// +build go1.20
+
+package signer_test
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	yubihsm "github.com/certusone/yubihsm-go"
+	"github.com/certusone/yubihsm-go/commands"
+	"github.com/certusone/yubihsm-go/fakehsm"
+	"github.com/certusone/yubihsm-go/signer"
+)
+
+func TestEd25519Options(t *testing.T) {
+	hsm := fakehsm.New()
+	hsm.AddAuthKey(1, "password")
+
+	manager, err := yubihsm.NewSessionManager(hsm, 1, "password", 1, yubihsm.DisableKeepAlive())
+	if err != nil {
+		t.Fatalf("creating session manager failed: %v", err)
+	}
+	defer manager.Destroy()
+
+	keyID, err := yubihsm.NewClient(manager).GenerateEd25519Key(0, "test", 1, commands.CapabilityAsymmetricSignEddsa)
+	if err != nil {
+		t.Fatalf("generating key failed: %v", err)
+	}
+	hsmSigner, err := signer.NewHSMSigner(manager, keyID, commands.AlgorithmED25519)
+	if err != nil {
+		t.Fatalf("creating signer failed: %v", err)
+	}
+
+	message := []byte("message")
+	tests := []struct {
+		name    string
+		opts    crypto.SignerOpts
+		wantErr bool
+	}{
+		{"plain", crypto.Hash(0), false},
+		{"options", &ed25519.Options{}, false},
+		{"ed25519ph", &ed25519.Options{Hash: crypto.SHA512}, true},
+		{"ed25519ctx", &ed25519.Options{Context: "context"}, true},
+		{"prehashed", crypto.SHA512, true},
+	}
+
+	for _, test := range tests {
+		signature, err := hsmSigner.Sign(rand.Reader, message, test.opts)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: signing failed: %v", test.name, err)
+			continue
+		}
+		if !ed25519.Verify(hsmSigner.Public().(ed25519.PublicKey), message, signature) {
+			t.Errorf("%s: signature is invalid", test.name)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package yubihsm
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/asn1"
+	"math/big"
+)
+
+// VerifyEddsa reports whether sig is a valid Ed25519 signature of msg by pub, e.g. as returned by SignDataEddsa
+func VerifyEddsa(pub ed25519.PublicKey, msg, sig []byte) bool {
+	if len(pub) != ed25519.PublicKeySize {
+		return false
+	}
+
+	return ed25519.Verify(pub, msg, sig)
+}
+
+// VerifyEcdsa reports whether derSig is a valid DER encoded ECDSA signature of digest by pub, e.g. as returned by
+// SignDataEcdsa
+func VerifyEcdsa(pub *ecdsa.PublicKey, digest, derSig []byte) bool {
+	var signature struct {
+		R, S *big.Int
+	}
+
+	rest, err := asn1.Unmarshal(derSig, &signature)
+	if err != nil || len(rest) != 0 {
+		return false
+	}
+
+	return ecdsa.Verify(pub, digest, signature.R, signature.S)
+}
+
+// VerifyPkcs1 verifies the RSASSA-PKCS1-v1_5 signature sig of digest by pub, e.g. as returned by SignDataPkcs1.
+// digest must have been computed using hash. A nil error means the signature is valid.
+func VerifyPkcs1(pub *rsa.PublicKey, hash crypto.Hash, digest, sig []byte) error {
+	return rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+}